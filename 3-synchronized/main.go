@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"net"
 	"strconv"
@@ -42,7 +41,7 @@ func main() {
 	for i := fp; i <= tp; i++ {
 		go func(p int) {
 			defer wg.Done()
-			conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", host, p))
+			conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(p)))
 			if err != nil {
 				log.Printf("%d CLOSED (%s)\n", p, err)
 				return
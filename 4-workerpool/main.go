@@ -101,7 +101,7 @@ func parsePortsToScan(portsFlag string) ([]int, error) {
 
 func worker(host string, portsChan <-chan int, resultsChan chan<- int) {
 	for p := range portsChan {
-		address := fmt.Sprintf("%s:%d", host, p)
+		address := net.JoinHostPort(host, strconv.Itoa(p))
 		conn, err := net.Dial("tcp", address)
 		if err != nil {
 			fmt.Printf("%d CLOSED (%s)\n", p, err)
@@ -111,7 +111,7 @@ func parsePortsToScan(portsFlag string) ([]int, error) {
 }
 
 func scan(host string, port int) int {
-	address := fmt.Sprintf("%s:%d", host, port)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		fmt.Printf("%d CLOSED (%s)\n", port, err)
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// policy declares which ports each host is expected to expose.
+//
+//	ports: 1-1024
+//	default: closed
+//	hosts:
+//	  127.0.0.1:
+//	    open: [22, 443]
+//	    closed: [23]
+type policy struct {
+	// Ports is scanned on every host to check the default expectation,
+	// in the same format as the -ports flag.
+	Ports string `yaml:"ports"`
+	// Default is what is expected of ports not listed for a host: "closed"
+	// or "any". It can be overridden per host.
+	Default string                `yaml:"default"`
+	Hosts   map[string]hostPolicy `yaml:"hosts"`
+}
+
+type hostPolicy struct {
+	Open    []int  `yaml:"open"`
+	Closed  []int  `yaml:"closed"`
+	Default string `yaml:"default"`
+}
+
+const (
+	defaultClosed = "closed"
+	defaultAny    = "any"
+)
+
+func loadPolicy(path string) (*policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Hosts) == 0 {
+		return nil, errors.New("policy declares no hosts")
+	}
+	if p.Default == "" {
+		p.Default = defaultClosed
+	}
+	for host, hp := range p.Hosts {
+		if hp.Default == "" {
+			hp.Default = p.Default
+			p.Hosts[host] = hp
+		}
+		if hp.Default != defaultClosed && hp.Default != defaultAny {
+			return nil, fmt.Errorf("host %s: default must be %q or %q, got %q", host, defaultClosed, defaultAny, hp.Default)
+		}
+		if hp.Default == defaultClosed && p.Ports == "" {
+			return nil, fmt.Errorf("host %s: a ports range is required to check that everything else is closed", host)
+		}
+	}
+	return &p, nil
+}
+
+// portsFor returns the ports that must be scanned on host to evaluate the
+// policy, in ascending order.
+func (p *policy) portsFor(host string) ([]int, error) {
+	hp := p.Hosts[host]
+	set := make(map[int]bool)
+	for _, port := range hp.Open {
+		set[port] = true
+	}
+	for _, port := range hp.Closed {
+		set[port] = true
+	}
+	if hp.Default == defaultClosed {
+		spec, err := parsePortSpec(p.Ports)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range spec.tcp {
+			set[port] = true
+		}
+	}
+
+	ports := make([]int, 0, len(set))
+	for port := range set {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// check is the outcome of evaluating the policy, or looking for findings,
+// for one port on one host.
+type check struct {
+	Host string
+	// IP is the address the port was checked on, set when the host was
+	// scanned at several addresses.
+	IP      string
+	Port    int
+	Expect  string
+	Got     string
+	Failure string
+
+	// Severity is the JUnit failure type, "policy" when empty.
+	Severity string
+}
+
+func (c check) name() string {
+	if c.IP != "" {
+		return fmt.Sprintf("port %d on %s %s", c.Port, c.IP, c.Expect)
+	}
+	return fmt.Sprintf("port %d %s", c.Port, c.Expect)
+}
+
+// evaluate checks the scan results for one host against its policy. Only
+// open ports count as exposed; closed and filtered ports both satisfy a
+// closed expectation.
+func (p *policy) evaluate(host string, results []Result) []check {
+	hp := p.Hosts[host]
+	byPort := make(map[int]Result, len(results))
+	for _, r := range results {
+		byPort[r.Port] = r
+	}
+	stateOf := func(port int) string {
+		if r, ok := byPort[port]; ok {
+			return r.State
+		}
+		return "unscanned"
+	}
+
+	listed := make(map[int]bool)
+	var checks []check
+	for _, port := range hp.Open {
+		listed[port] = true
+		c := check{Host: host, Port: port, Expect: "open", Got: stateOf(port)}
+		if c.Got != stateOpen {
+			c.Failure = fmt.Sprintf("expected port %d to be open, got %s", port, c.Got)
+		}
+		checks = append(checks, c)
+	}
+	for _, port := range hp.Closed {
+		listed[port] = true
+		c := check{Host: host, Port: port, Expect: "closed", Got: stateOf(port)}
+		if c.Got == stateOpen {
+			c.Failure = fmt.Sprintf("expected port %d to be closed, but it is open", port)
+		}
+		checks = append(checks, c)
+	}
+	if hp.Default == defaultClosed {
+		for _, r := range results {
+			if listed[r.Port] || r.State != stateOpen {
+				continue
+			}
+			checks = append(checks, check{
+				Host:    host,
+				Port:    r.Port,
+				Expect:  "closed (default)",
+				Got:     r.State,
+				Failure: fmt.Sprintf("port %d is open but not allowed by the policy", r.Port),
+			})
+		}
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Port < checks[j].Port })
+	return checks
+}
+
+// runAssert implements the assert subcommand, which scans the hosts in a
+// policy and checks the results against it. It returns the process exit
+// code: 0 when the policy holds, 1 when it is violated and 2 when the check
+// could not be carried out.
+func runAssert(args []string) int {
+	fs := flag.NewFlagSet("assert", flag.ContinueOnError)
+	policyFile := fs.String("policy", "policy.yaml", "Policy declaring the expected port states.")
+	junitFile := fs.String("junit", "", "Destination of a JUnit XML report (disabled by default).")
+	fs.IntVar(&workers, "workers", workers, "Number of concurrent scanners.")
+	fs.DurationVar(&timeout, "timeout", timeout, "Timeout for each connection attempt.")
+	addScopeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := checkScanFlags(); err != nil {
+		fmt.Printf("Failed to parse flags: %s\n", err)
+		return 2
+	}
+
+	p, err := loadPolicy(*policyFile)
+	if err != nil {
+		fmt.Printf("Failed to load policy: %s\n", err)
+		return 2
+	}
+
+	sc, err := loadScope()
+	if err != nil {
+		fmt.Printf("Failed to load scan scope: %s\n", err)
+		return 2
+	}
+
+	// Hosts the scope excludes are left out of the report rather than
+	// failing their open expectations.
+	names := newResolver("")
+	var hosts []string
+	var gens []<-chan Result
+	var excluded, probes int
+	for host := range p.Hosts {
+		ports, err := p.portsFor(host)
+		if err != nil {
+			fmt.Printf("Failed to parse ports to scan: %s\n", err)
+			return 2
+		}
+		targets, n, err := sc.apply(expandTargets([]string{host}, names, sc.needsAddresses()))
+		if err != nil {
+			fmt.Printf("Refusing to scan: %s\n", err)
+			return 2
+		}
+		excluded += n
+		if len(targets) == 0 {
+			continue
+		}
+		hosts = append(hosts, host)
+		probes += len(targets) * len(ports)
+		gens = append(gens, gen(targets, protoTCP, ports...))
+	}
+	sort.Strings(hosts)
+	if excluded > 0 {
+		fmt.Fprintf(os.Stderr, "Excluded %d target(s)\n", excluded)
+	}
+	if !confirmScan(len(hosts), probes, os.Stdin, os.Stderr) {
+		return 2
+	}
+
+	start := time.Now()
+	byHost := make(map[string][]Result)
+	for r := range scanAll(merge(gens...), workers) {
+		byHost[r.Host] = append(byHost[r.Host], r)
+	}
+	elapsed := time.Since(start)
+
+	var checks []check
+	var failures int
+	for _, host := range hosts {
+		for _, c := range p.evaluate(host, byHost[host]) {
+			if c.Failure != "" {
+				fmt.Printf("FAIL %s: %s\n", host, c.Failure)
+				failures++
+			}
+			checks = append(checks, c)
+		}
+	}
+	fmt.Printf("%d checks, %d violations\n", len(checks), failures)
+
+	if *junitFile != "" {
+		f, err := os.Create(*junitFile)
+		if err != nil {
+			fmt.Printf("Failed to create JUnit report: %s\n", err)
+			return 2
+		}
+		err = writeJUnit(f, checks, elapsed)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fmt.Printf("Failed to write JUnit report: %s\n", err)
+			return 2
+		}
+	}
+
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes checks as a JUnit XML report with one test suite per
+// host, so that CI dashboards can show policy violations as test failures.
+func writeJUnit(w io.Writer, checks []check, elapsed time.Duration) error {
+	report := junitTestSuites{}
+	suites := make(map[string]int)
+	for _, c := range checks {
+		i, ok := suites[c.Host]
+		if !ok {
+			i = len(report.Suites)
+			suites[c.Host] = i
+			report.Suites = append(report.Suites, junitTestSuite{
+				Name: "portscan." + c.Host,
+				Time: fmt.Sprintf("%.3f", elapsed.Seconds()),
+			})
+		}
+		suite := &report.Suites[i]
+
+		tc := junitTestCase{Name: c.name(), ClassName: suite.Name}
+		if c.Failure != "" {
+			failureType := c.Severity
+			if failureType == "" {
+				failureType = "policy"
+			}
+			tc.Failure = &junitFailure{Message: c.Failure, Type: failureType, Text: fmt.Sprintf("expected %s, got %s", c.Expect, c.Got)}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		report.Tests++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var bannerGrab bool
+var bannerWait time.Duration
+var bannerMaxBytes int
+var bannerNudge string
+
+func init() {
+	flag.BoolVar(&bannerGrab, "banner", false, "Read a banner from each open port.")
+	flag.DurationVar(&bannerWait, "banner-wait", 2*time.Second, "How long to wait for a banner.")
+	flag.IntVar(&bannerMaxBytes, "banner-bytes", 1024, "Maximum number of banner bytes to keep.")
+	flag.StringVar(&bannerNudge, "banner-nudge", "", `Payload to send when a port stays silent, with Go escapes (e.g. \r\n).`)
+}
+
+// maxBannerBytes bounds how much a single read of a banner, or of a
+// script's read(), may ask for, since the whole buffer is allocated upfront.
+const maxBannerBytes = 1 << 20
+
+// grabBanner connects to an open port and reads whatever the server sends
+// first, which identifies server-first protocols such as SSH, SMTP, FTP and
+// Redis. If nothing arrives and a nudge payload is configured, the payload is
+// sent and the port is given another chance to answer.
+func grabBanner(r *Result) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	raw := readBanner(conn, bannerWait, bannerMaxBytes)
+	if len(raw) == 0 && bannerNudge != "" {
+		nudge, err := strconv.Unquote(`"` + bannerNudge + `"`)
+		if err != nil {
+			nudge = bannerNudge
+		}
+		conn.SetWriteDeadline(time.Now().Add(bannerWait))
+		if _, err := conn.Write([]byte(nudge)); err == nil {
+			raw = readBanner(conn, bannerWait, bannerMaxBytes)
+		}
+	}
+
+	if len(raw) > 0 {
+		r.BannerRaw = raw
+		r.Banner = sanitizeBanner(raw)
+	}
+}
+
+// readBanner reads up to max bytes from conn. It waits up to wait for the
+// first bytes and then keeps reading for as long as more arrive promptly, so
+// multi-line greetings are captured without always waiting the full time.
+func readBanner(conn net.Conn, wait time.Duration, max int) []byte {
+	const idle = 200 * time.Millisecond
+
+	buf := make([]byte, max)
+	var n int
+	deadline := time.Now().Add(wait)
+	for n < max {
+		conn.SetReadDeadline(deadline)
+		m, err := conn.Read(buf[n:])
+		n += m
+		if err != nil {
+			break
+		}
+		if next := time.Now().Add(idle); next.Before(deadline) {
+			deadline = next
+		}
+	}
+	return buf[:n]
+}
+
+// sanitizeBanner turns raw banner bytes into a single printable line:
+// surrounding whitespace is trimmed and anything that is not printable ASCII
+// is written as a Go escape sequence.
+func sanitizeBanner(raw []byte) string {
+	var b strings.Builder
+	for _, c := range []byte(strings.TrimSpace(string(raw))) {
+		switch {
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c < 0x20 || c > 0x7e:
+			b.WriteString(`\x`)
+			b.WriteString(strconv.FormatUint(uint64(c)|0x100, 16)[1:])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
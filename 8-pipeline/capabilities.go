@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var capabilityProbe bool
+var ftpAnonymous bool
+
+func init() {
+	flag.BoolVar(&capabilityProbe, "capabilities", false, "Read the greeting and capabilities of SMTP, FTP, IMAP and POP3 servers.")
+	flag.BoolVar(&ftpAnonymous, "ftp-anonymous", false, "Check whether FTP servers accept anonymous logins (implies -capabilities).")
+}
+
+// capabilityInfo is what a mail or file transfer server advertised in
+// answer to its protocol's capability command.
+type capabilityInfo struct {
+	Protocol       string   `json:"protocol"`
+	Greeting       string   `json:"greeting"`
+	Capabilities   []string `json:"capabilities,omitempty"`
+	StartTLS       bool     `json:"startTLS"`
+	AuthMechanisms []string `json:"authMechanisms,omitempty"`
+	// MaxSize is the message size limit advertised by SMTP servers.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// System is the FTP server's answer to SYST.
+	System         string `json:"system,omitempty"`
+	AnonymousLogin bool   `json:"anonymousLogin,omitempty"`
+}
+
+// capabilityProber reads the capabilities of one protocol over a connection
+// on which nothing has been read yet.
+type capabilityProber func(tc *textproto.Conn) (*capabilityInfo, error)
+
+// capabilityProbers picks the prober for a port from the service the port
+// was identified as, or failing that the port's well-known service. Implicit
+// TLS variants share the prober of their plaintext protocol.
+var capabilityProbers = map[string]capabilityProber{
+	"smtp":       probeSMTP,
+	"submission": probeSMTP,
+	"smtps":      probeSMTP,
+	"ftp":        probeFTP,
+	"imap":       probeIMAP,
+	"imaps":      probeIMAP,
+	"pop3":       probePOP3,
+	"pop3s":      probePOP3,
+}
+
+// implicitTLS lists the services that speak TLS from the start of the
+// connection rather than upgrading with STARTTLS.
+var implicitTLS = map[string]bool{
+	"smtps": true,
+	"imaps": true,
+	"pop3s": true,
+}
+
+// probeCapabilities sends read-only capability commands to SMTP, FTP, IMAP
+// and POP3 servers. Ports whose service is unknown are recognised by the
+// greeting in their banner. Implicit TLS ports are spoken to over TLS
+// whether or not the TLS probe ran.
+func probeCapabilities(r *Result) {
+	prober, ok := capabilityProbers[r.Service]
+	if !ok {
+		prober, ok = capabilityProbers[serviceName(r.Protocol, r.Port)]
+	}
+	if !ok {
+		prober, ok = capabilityProbers[greetingService(r.Banner)]
+	}
+	if !ok {
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	if r.TLS != nil || implicitTLS[r.Service] || implicitTLS[serviceName(r.Protocol, r.Port)] {
+		conn = tls.Client(conn, &tls.Config{ServerName: r.Host, InsecureSkipVerify: true})
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	tc := textproto.NewConn(conn)
+	defer tc.Close()
+
+	info, err := prober(tc)
+	if err != nil {
+		return
+	}
+	r.Capabilities = info
+	if r.Service == "" || r.Service == "unknown" {
+		r.Service = info.Protocol
+	}
+	if info.AnonymousLogin {
+		r.addFinding("ftp-anonymous-login", severityMedium, "Anonymous FTP login allowed", info.Greeting)
+	}
+}
+
+// greetingService guesses the protocol of a port from its greeting.
+func greetingService(banner string) string {
+	upper := strings.ToUpper(banner)
+	switch {
+	case strings.HasPrefix(upper, "220") && strings.Contains(upper, "SMTP"):
+		return "smtp"
+	case strings.HasPrefix(upper, "220") && strings.Contains(upper, "FTP"):
+		return "ftp"
+	case strings.HasPrefix(upper, "* OK"), strings.HasPrefix(upper, "* PREAUTH"):
+		return "imap"
+	case strings.HasPrefix(upper, "+OK"):
+		return "pop3"
+	}
+	return ""
+}
+
+// probeSMTP reads the EHLO extensions: STARTTLS, AUTH mechanisms and the
+// SIZE limit.
+func probeSMTP(tc *textproto.Conn) (*capabilityInfo, error) {
+	_, greeting, err := tc.ReadResponse(220)
+	if err != nil {
+		return nil, err
+	}
+	info := &capabilityInfo{Protocol: "smtp", Greeting: firstLine(greeting)}
+	defer tc.PrintfLine("QUIT")
+
+	if err := tc.PrintfLine("EHLO portscan"); err != nil {
+		return nil, err
+	}
+	_, msg, err := tc.ReadResponse(250)
+	if isProtocolError(err) {
+		// Servers that only speak HELO have no extensions to list.
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The first line of the reply is the server's hostname.
+	for _, ext := range strings.Split(msg, "\n")[1:] {
+		info.Capabilities = append(info.Capabilities, ext)
+		fields := strings.Fields(strings.Replace(ext, "=", " ", 1))
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "STARTTLS":
+			info.StartTLS = true
+		case "AUTH":
+			info.AuthMechanisms = appendUnique(info.AuthMechanisms, fields[1:]...)
+		case "SIZE":
+			if len(fields) > 1 {
+				info.MaxSize, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		}
+	}
+	return info, nil
+}
+
+// probeFTP reads the FEAT and SYST replies and, when enabled, tries an
+// anonymous login.
+func probeFTP(tc *textproto.Conn) (*capabilityInfo, error) {
+	_, greeting, err := tc.ReadResponse(220)
+	if err != nil {
+		return nil, err
+	}
+	info := &capabilityInfo{Protocol: "ftp", Greeting: firstLine(greeting)}
+	defer tc.PrintfLine("QUIT")
+
+	// Servers that do not implement FEAT answer 500 or 502, which just
+	// means there are no features to list.
+	if _, msg, err := ftpCommand(tc, 211, "FEAT"); err == nil {
+		lines := strings.Split(msg, "\n")
+		for _, line := range lines[1:] {
+			if feat := strings.TrimSpace(line); feat != "" && !strings.EqualFold(feat, "End") {
+				info.Capabilities = append(info.Capabilities, feat)
+				// FTP's AUTH negotiates TLS rather than a login mechanism.
+				if strings.HasPrefix(strings.ToUpper(feat), "AUTH TLS") {
+					info.StartTLS = true
+				}
+			}
+		}
+	} else if !isProtocolError(err) {
+		return nil, err
+	}
+
+	if _, msg, err := ftpCommand(tc, 215, "SYST"); err == nil {
+		info.System = firstLine(msg)
+	} else if !isProtocolError(err) {
+		return nil, err
+	}
+
+	if ftpAnonymous {
+		code, _, err := ftpCommand(tc, 3, "USER anonymous")
+		if err == nil && code == 331 {
+			code, _, err = ftpCommand(tc, 2, "PASS anonymous@example.com")
+		}
+		info.AnonymousLogin = err == nil && code == 230
+	}
+	return info, nil
+}
+
+// ftpCommand sends cmd and reads the reply, which must start with expect.
+// A 230 reply to USER is accepted when expecting 3, since some servers
+// let anonymous users in without a password.
+func ftpCommand(tc *textproto.Conn, expect int, cmd string) (int, string, error) {
+	if err := tc.PrintfLine("%s", cmd); err != nil {
+		return 0, "", err
+	}
+	code, msg, err := tc.ReadResponse(expect)
+	if err != nil && code == 230 {
+		return code, msg, nil
+	}
+	return code, msg, err
+}
+
+// probeIMAP reads the CAPABILITY response.
+func probeIMAP(tc *textproto.Conn) (*capabilityInfo, error) {
+	greeting, err := tc.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return nil, errors.New("not an IMAP greeting")
+	}
+	info := &capabilityInfo{Protocol: "imap", Greeting: greeting}
+	defer tc.PrintfLine("a2 LOGOUT")
+
+	if err := tc.PrintfLine("a1 CAPABILITY"); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			break
+		}
+		if !strings.HasPrefix(strings.ToUpper(line), "* CAPABILITY ") {
+			continue
+		}
+		for _, c := range strings.Fields(line)[2:] {
+			info.Capabilities = append(info.Capabilities, c)
+			upper := strings.ToUpper(c)
+			switch {
+			case upper == "STARTTLS":
+				info.StartTLS = true
+			case strings.HasPrefix(upper, "AUTH="):
+				info.AuthMechanisms = appendUnique(info.AuthMechanisms, c[len("AUTH="):])
+			}
+		}
+	}
+	return info, nil
+}
+
+// probePOP3 reads the CAPA response.
+func probePOP3(tc *textproto.Conn) (*capabilityInfo, error) {
+	greeting, err := tc.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return nil, errors.New("not a POP3 greeting")
+	}
+	info := &capabilityInfo{Protocol: "pop3", Greeting: greeting}
+	defer tc.PrintfLine("QUIT")
+
+	if err := tc.PrintfLine("CAPA"); err != nil {
+		return nil, err
+	}
+	status, err := tc.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	// Servers predating CAPA answer -ERR, leaving nothing to list.
+	if !strings.HasPrefix(status, "+OK") {
+		return info, nil
+	}
+	lines, err := tc.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range lines {
+		info.Capabilities = append(info.Capabilities, c)
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "STLS":
+			info.StartTLS = true
+		case "SASL":
+			info.AuthMechanisms = appendUnique(info.AuthMechanisms, fields[1:]...)
+		}
+	}
+	return info, nil
+}
+
+func isProtocolError(err error) bool {
+	var pe *textproto.Error
+	return errors.As(err, &pe)
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, l := range list {
+			if l == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// capabilityColumns returns the CSV columns describing the advertised
+// capabilities, or empty columns when none were read.
+func (r Result) capabilityColumns() []string {
+	ci := r.Capabilities
+	if ci == nil {
+		return make([]string, 3)
+	}
+	return []string{
+		strings.Join(ci.Capabilities, "; "),
+		strconv.FormatBool(ci.StartTLS),
+		strings.Join(ci.AuthMechanisms, " "),
+	}
+}
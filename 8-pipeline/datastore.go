@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var datastoreChecks bool
+
+func init() {
+	flag.BoolVar(&datastoreChecks, "datastore-checks", false, "Check whether datastores on open ports accept unauthenticated access.")
+}
+
+// datastoreCheck probes an open port for a particular datastore and records
+// a finding describing whether it can be used without credentials. Checks
+// only ever issue read-only commands.
+type datastoreCheck func(r *Result)
+
+// datastoreChecksByService picks the check for a port from the service the
+// port was identified as, or failing that the port's well-known service.
+var datastoreChecksByService = map[string]datastoreCheck{
+	"redis":         checkRedis,
+	"memcache":      checkMemcached,
+	"memcached":     checkMemcached,
+	"mongodb":       checkMongoDB,
+	"elasticsearch": checkElasticsearch,
+	"postgresql":    checkPostgreSQL,
+	"mysql":         checkMySQL,
+	"zookeeper":     checkZooKeeper,
+}
+
+func checkDatastore(r *Result) {
+	check, ok := datastoreChecksByService[r.Service]
+	if !ok {
+		check, ok = datastoreChecksByService[serviceName(r.Protocol, r.Port)]
+	}
+	if ok {
+		check(r)
+	}
+}
+
+// addDatastoreFinding records a finding with structured data and fills in
+// the product and version if nothing else identified them.
+func (r *Result) addDatastoreFinding(id, severity, title, product string, data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var detail []string
+	for _, k := range keys {
+		detail = append(detail, k+"="+data[k])
+	}
+
+	r.Findings = append(r.Findings, finding{ID: id, Severity: severity, Title: title, Detail: strings.Join(detail, " "), Data: data})
+	if r.Product == "" {
+		r.Product = product
+		r.Version = data["version"]
+	}
+}
+
+// roundTrip connects to the port, sends req and reads the reply until it
+// ends with terminator or the timeout expires.
+func roundTrip(r *Result, req []byte, terminator string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	chunk := make([]byte, 4096)
+	for buf.Len() < 1<<20 {
+		n, err := conn.Read(chunk)
+		buf.Write(chunk[:n])
+		if bytes.HasSuffix(buf.Bytes(), []byte(terminator)) {
+			break
+		}
+		if err != nil {
+			if buf.Len() > 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// checkRedis sends PING and, if that is answered without authentication,
+// INFO server to find the version.
+func checkRedis(r *Result) {
+	reply, err := roundTrip(r, []byte("PING\r\n"), "\r\n")
+	if err != nil {
+		return
+	}
+	switch {
+	case bytes.HasPrefix(reply, []byte("+PONG")):
+	case bytes.HasPrefix(reply, []byte("-NOAUTH")), bytes.HasPrefix(reply, []byte("-WRONGPASS")):
+		r.addDatastoreFinding("redis-auth-required", severityInfo, "Redis requires authentication", "Redis", nil)
+		return
+	default:
+		return
+	}
+
+	data := map[string]string{}
+	if info, err := roundTrip(r, []byte("INFO server\r\n"), "\r\n\r\n"); err == nil {
+		for _, line := range strings.Split(string(info), "\r\n") {
+			if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+				switch kv[0] {
+				case "redis_version":
+					data["version"] = kv[1]
+				case "redis_mode", "os":
+					data[kv[0]] = kv[1]
+				}
+			}
+		}
+	}
+	r.addDatastoreFinding("redis-unauthenticated", severityHigh, "Redis accepts commands without authentication", "Redis", data)
+}
+
+// checkMemcached sends stats, which memcached answers without
+// authentication unless SASL is enabled.
+func checkMemcached(r *Result) {
+	reply, err := roundTrip(r, []byte("stats\r\n"), "END\r\n")
+	if err != nil || !bytes.HasPrefix(reply, []byte("STAT ")) {
+		return
+	}
+	data := map[string]string{}
+	for _, line := range strings.Split(string(reply), "\r\n") {
+		f := strings.Fields(line)
+		if len(f) == 3 && f[0] == "STAT" && (f[1] == "version" || f[1] == "curr_items" || f[1] == "uptime") {
+			data[f[1]] = f[2]
+		}
+	}
+	r.addDatastoreFinding("memcached-unauthenticated", severityHigh, "Memcached accepts commands without authentication", "Memcached", data)
+}
+
+// checkElasticsearch requests the root document, which only needs
+// credentials when security is enabled.
+func checkElasticsearch(r *Result) {
+	scheme := "http"
+	if r.HTTP != nil {
+		scheme = r.HTTP.Scheme
+	} else if r.TLS != nil {
+		scheme = "https"
+	}
+	resp, body, _, err := httpGet(r, scheme, "/")
+	if err != nil {
+		return
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		r.addDatastoreFinding("elasticsearch-auth-required", severityInfo, "Elasticsearch requires authentication", "Elasticsearch", nil)
+		return
+	}
+
+	var root struct {
+		ClusterName string `json:"cluster_name"`
+		Version     struct {
+			Number string `json:"number"`
+		} `json:"version"`
+		Tagline string `json:"tagline"`
+	}
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &root) != nil || root.Version.Number == "" {
+		return
+	}
+	r.addDatastoreFinding("elasticsearch-unauthenticated", severityHigh, "Elasticsearch accepts requests without authentication", "Elasticsearch",
+		map[string]string{"version": root.Version.Number, "cluster_name": root.ClusterName})
+}
+
+// checkZooKeeper sends the srvr four-letter word, which reports the server
+// version and mode to anyone who asks.
+func checkZooKeeper(r *Result) {
+	reply, err := roundTrip(r, []byte("srvr"), "\x00")
+	if err != nil {
+		return
+	}
+	data := map[string]string{}
+	for _, line := range strings.Split(string(reply), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "Zookeeper version":
+			data["version"] = strings.SplitN(v, "-", 2)[0]
+		case "Mode":
+			data["mode"] = v
+		case "Node count":
+			data["node_count"] = v
+		}
+	}
+	if data["version"] == "" {
+		return
+	}
+	r.addDatastoreFinding("zookeeper-unauthenticated", severityMedium, "ZooKeeper answers four-letter-word commands", "ZooKeeper", data)
+}
+
+// checkMySQL reads the handshake MySQL sends on connect, which includes the
+// server version.
+func checkMySQL(r *Result) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length < 2 || length > 1<<16 {
+		return
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return
+	}
+
+	// Protocol version 10 is followed by the NUL-terminated version;
+	// 0xff means the server refused us, for example host not allowed.
+	if payload[0] != 10 {
+		return
+	}
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 0 {
+		return
+	}
+	version := string(payload[1 : 1+end])
+	r.addDatastoreFinding("mysql-version-disclosed", severityInfo, "MySQL discloses its version before authentication", "MySQL",
+		map[string]string{"version": version})
+}
+
+// postgresAuthMethods names the authentication requests a PostgreSQL server
+// can answer a startup message with.
+var postgresAuthMethods = map[uint32]string{
+	0:  "trust",
+	2:  "kerberos",
+	3:  "password",
+	5:  "md5",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+// checkPostgreSQL sends a startup message for the postgres user. A server
+// that answers with AuthenticationOk trusts the connection without a
+// password; otherwise the requested authentication method is recorded. The
+// connection is terminated before any query is sent.
+func checkPostgreSQL(r *Result) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var params bytes.Buffer
+	params.WriteString("user\x00postgres\x00database\x00postgres\x00\x00")
+	startup := make([]byte, 8, 8+params.Len())
+	binary.BigEndian.PutUint32(startup, uint32(8+params.Len()))
+	binary.BigEndian.PutUint32(startup[4:], 196608) // protocol 3.0
+	startup = append(startup, params.Bytes()...)
+	if _, err := conn.Write(startup); err != nil {
+		return
+	}
+	defer conn.Write([]byte{'X', 0, 0, 0, 4})
+
+	br := bufio.NewReader(conn)
+	data := map[string]string{}
+	for {
+		msgType, body, err := readPostgresMessage(br)
+		if err != nil {
+			return
+		}
+		switch msgType {
+		case 'E':
+			return
+		case 'R':
+			if len(body) < 4 {
+				return
+			}
+			method := binary.BigEndian.Uint32(body)
+			name, ok := postgresAuthMethods[method]
+			if !ok {
+				name = fmt.Sprint(method)
+			}
+			data["auth"] = name
+			if method != 0 {
+				r.addDatastoreFinding("postgresql-auth-required", severityInfo, "PostgreSQL requires "+name+" authentication", "PostgreSQL", data)
+				return
+			}
+		case 'S':
+			kv := bytes.SplitN(body, []byte{0}, 3)
+			if len(kv) >= 2 && string(kv[0]) == "server_version" {
+				data["version"] = string(kv[1])
+			}
+		case 'Z':
+			r.addDatastoreFinding("postgresql-trust-auth", severityCritical, "PostgreSQL accepts the postgres user without a password", "PostgreSQL", data)
+			return
+		}
+	}
+}
+
+func readPostgresMessage(br *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > 1<<20 {
+		return 0, nil, errors.New("malformed PostgreSQL message")
+	}
+	body := make([]byte, length-4)
+	_, err := io.ReadFull(br, body)
+	return header[0], body, err
+}
+
+// checkMongoDB opens with isMaster, the handshake every MongoDB version
+// accepts in the legacy OP_QUERY format, then runs buildInfo and
+// listDatabases using OP_MSG, which MongoDB 3.6 introduced and 6.0 requires
+// for every other command. Older servers are sent OP_QUERY throughout. If
+// listDatabases succeeds the server does not enforce authentication.
+func checkMongoDB(r *Result) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	hello, err := mongoCommand(conn, "isMaster", false)
+	if err != nil {
+		return
+	}
+	// Wire version 6 is MongoDB 3.6, the first to accept OP_MSG.
+	wire, _ := hello["maxWireVersion"].(float64)
+	opMsg := wire >= 6
+
+	data := map[string]string{}
+	if info, err := mongoCommand(conn, "buildInfo", opMsg); err == nil {
+		if v, ok := info["version"].(string); ok {
+			data["version"] = v
+		}
+	}
+
+	dbs, err := mongoCommand(conn, "listDatabases", opMsg)
+	if err != nil {
+		return
+	}
+	if ok, _ := dbs["ok"].(float64); ok == 1 {
+		r.addDatastoreFinding("mongodb-unauthenticated", severityHigh, "MongoDB lists databases without authentication", "MongoDB", data)
+		return
+	}
+	r.addDatastoreFinding("mongodb-auth-required", severityInfo, "MongoDB requires authentication", "MongoDB", data)
+}
+
+// MongoDB wire protocol opcodes.
+const (
+	mongoOpReply = 1
+	mongoOpQuery = 2004
+	mongoOpMsg   = 2013
+)
+
+// mongoCommand runs {cmd: 1} against the admin database, as an OP_MSG when
+// opMsg is set and an OP_QUERY otherwise, and returns the top-level fields
+// of the reply.
+func mongoCommand(conn net.Conn, cmd string, opMsg bool) (map[string]interface{}, error) {
+	var doc bytes.Buffer
+	doc.Write([]byte{0, 0, 0, 0, 0x10})
+	doc.WriteString(cmd + "\x00")
+	doc.Write([]byte{1, 0, 0, 0})
+	if opMsg {
+		// OP_MSG names the database in the command itself.
+		doc.WriteString("\x02$db\x00")
+		binary.Write(&doc, binary.LittleEndian, int32(len("admin")+1))
+		doc.WriteString("admin\x00")
+	}
+	doc.WriteByte(0)
+	command := doc.Bytes()
+	binary.LittleEndian.PutUint32(command, uint32(len(command)))
+
+	var msg bytes.Buffer
+	msg.Write(make([]byte, 16)) // header, filled in below
+	opCode := uint32(mongoOpQuery)
+	if opMsg {
+		opCode = mongoOpMsg
+		msg.Write([]byte{0, 0, 0, 0}) // flagBits
+		msg.WriteByte(0)              // section kind 0: a single document
+	} else {
+		msg.Write([]byte{0, 0, 0, 0})
+		msg.WriteString("admin.$cmd\x00")
+		binary.Write(&msg, binary.LittleEndian, int32(0))  // numberToSkip
+		binary.Write(&msg, binary.LittleEndian, int32(-1)) // numberToReturn
+	}
+	msg.Write(command)
+	b := msg.Bytes()
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[4:], 1) // requestID
+	binary.LittleEndian.PutUint32(b[12:], opCode)
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	var header [16]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:])
+	replyOp := binary.LittleEndian.Uint32(header[12:])
+	if length < 21 || length > 16<<20 || (replyOp != mongoOpReply && replyOp != mongoOpMsg) {
+		return nil, errors.New("unexpected MongoDB reply")
+	}
+	reply := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if replyOp == mongoOpMsg {
+		// flagBits and the section kind precede the document.
+		if reply[4] != 0 {
+			return nil, errors.New("unexpected MongoDB reply")
+		}
+		return parseBSON(reply[5:])
+	}
+	// responseFlags, cursorID, startingFrom and numberReturned precede
+	// the first document.
+	if len(reply) < 20 {
+		return nil, errors.New("unexpected MongoDB reply")
+	}
+	return parseBSON(reply[20:])
+}
+
+// parseBSON decodes the top-level fields of a BSON document. Numbers are
+// returned as float64 and strings and booleans as themselves; nested
+// documents and other types are skipped.
+func parseBSON(b []byte) (map[string]interface{}, error) {
+	errMalformed := errors.New("malformed BSON document")
+	if len(b) < 5 {
+		return nil, errMalformed
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size > len(b) || size < 5 {
+		return nil, errMalformed
+	}
+	b = b[4 : size-1]
+
+	fields := make(map[string]interface{})
+	for len(b) > 0 {
+		typ := b[0]
+		end := bytes.IndexByte(b[1:], 0)
+		if end < 0 {
+			return nil, errMalformed
+		}
+		name := string(b[1 : 1+end])
+		b = b[2+end:]
+
+		var n int
+		switch typ {
+		case 0x01: // double
+			n = 8
+			if len(b) >= n {
+				fields[name] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			}
+		case 0x02: // string
+			if len(b) < 4 {
+				return nil, errMalformed
+			}
+			n = 4 + int(binary.LittleEndian.Uint32(b))
+			if len(b) >= n && n > 4 {
+				fields[name] = string(b[4 : n-1])
+			}
+		case 0x03, 0x04: // document, array
+			if len(b) < 4 {
+				return nil, errMalformed
+			}
+			n = int(binary.LittleEndian.Uint32(b))
+		case 0x05: // binary
+			if len(b) < 4 {
+				return nil, errMalformed
+			}
+			n = 5 + int(binary.LittleEndian.Uint32(b))
+		case 0x07: // ObjectId
+			n = 12
+		case 0x08: // bool
+			n = 1
+			if len(b) >= n {
+				fields[name] = b[0] == 1
+			}
+		case 0x09, 0x11, 0x12: // datetime, timestamp, int64
+			n = 8
+			if typ == 0x12 && len(b) >= n {
+				fields[name] = float64(int64(binary.LittleEndian.Uint64(b)))
+			}
+		case 0x0A: // null
+		case 0x10: // int32
+			n = 4
+			if len(b) >= n {
+				fields[name] = float64(int32(binary.LittleEndian.Uint32(b)))
+			}
+		case 0x13: // decimal128
+			n = 16
+		default:
+			return fields, nil
+		}
+		if n < 0 || n > len(b) {
+			return nil, errMalformed
+		}
+		b = b[n:]
+	}
+	return fields, nil
+}
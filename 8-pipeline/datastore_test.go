@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeServer listens on a local port, handing each connection to handle,
+// and returns an open result for the port.
+func fakeServer(t *testing.T, handle func(net.Conn)) *Result {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return &Result{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, Protocol: protoTCP, Open: true, State: stateOpen}
+}
+
+// datastoreTest is a check run against a fake server, along with the
+// finding and version it should record, if any.
+type datastoreTest struct {
+	name    string
+	check   datastoreCheck
+	handle  func(net.Conn)
+	finding string
+	version string
+}
+
+func runDatastoreTests(t *testing.T, tests []datastoreTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := fakeServer(t, tt.handle)
+			tt.check(r)
+			var ids []string
+			for _, f := range r.Findings {
+				ids = append(ids, f.ID)
+			}
+			want := []string{}
+			if tt.finding != "" {
+				want = append(want, tt.finding)
+			}
+			if strings.Join(ids, ",") != strings.Join(want, ",") {
+				t.Fatalf("findings = %v, want %v", ids, want)
+			}
+			if r.Version != tt.version {
+				t.Errorf("version = %q, want %q", r.Version, tt.version)
+			}
+		})
+	}
+}
+
+// lineServer answers each line it reads with reply(line), closing the
+// connection when reply returns an empty string.
+func lineServer(reply func(line string) string) func(net.Conn) {
+	return func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			resp := reply(strings.TrimSpace(line))
+			if resp == "" {
+				return
+			}
+			io.WriteString(conn, resp)
+		}
+	}
+}
+
+func fakeRedis(password bool) func(net.Conn) {
+	return lineServer(func(line string) string {
+		switch {
+		case password:
+			return "-NOAUTH Authentication required.\r\n"
+		case line == "PING":
+			return "+PONG\r\n"
+		case line == "INFO server":
+			info := "# Server\r\nredis_version:7.0.11\r\nredis_mode:standalone\r\nos:Linux\r\n"
+			return "$" + strconv.Itoa(len(info)) + "\r\n" + info + "\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+}
+
+func TestCheckRedis(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "open", check: checkRedis, handle: fakeRedis(false), finding: "redis-unauthenticated", version: "7.0.11"},
+		{name: "auth required", check: checkRedis, handle: fakeRedis(true), finding: "redis-auth-required"},
+	})
+}
+
+func fakeMemcached(sasl bool) func(net.Conn) {
+	return lineServer(func(line string) string {
+		// With SASL enabled memcached only speaks the binary protocol,
+		// and drops clients that send it text.
+		if sasl {
+			return ""
+		}
+		if line != "stats" {
+			return "ERROR\r\n"
+		}
+		return "STAT pid 1\r\nSTAT uptime 42\r\nSTAT version 1.6.21\r\nSTAT curr_items 7\r\nEND\r\n"
+	})
+}
+
+func TestCheckMemcached(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "open", check: checkMemcached, handle: fakeMemcached(false), finding: "memcached-unauthenticated", version: "1.6.21"},
+		{name: "sasl", check: checkMemcached, handle: fakeMemcached(true)},
+	})
+}
+
+// bsonDoc encodes name, value pairs as a BSON document. Values may be
+// strings, float64s, int32s or bools.
+func bsonDoc(fields ...interface{}) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 4))
+	for i := 0; i+1 < len(fields); i += 2 {
+		name := fields[i].(string) + "\x00"
+		switch v := fields[i+1].(type) {
+		case string:
+			b.WriteByte(0x02)
+			b.WriteString(name)
+			binary.Write(&b, binary.LittleEndian, int32(len(v)+1))
+			b.WriteString(v + "\x00")
+		case float64:
+			b.WriteByte(0x01)
+			b.WriteString(name)
+			binary.Write(&b, binary.LittleEndian, math.Float64bits(v))
+		case int32:
+			b.WriteByte(0x10)
+			b.WriteString(name)
+			binary.Write(&b, binary.LittleEndian, v)
+		case bool:
+			b.WriteByte(0x08)
+			b.WriteString(name)
+			if v {
+				b.WriteByte(1)
+			} else {
+				b.WriteByte(0)
+			}
+		}
+	}
+	b.WriteByte(0)
+	doc := b.Bytes()
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc
+}
+
+// fakeMongoDB behaves like MongoDB 6.0 and later, which only accepts the
+// isMaster handshake as an OP_QUERY and closes the connection on any other
+// OP_QUERY command.
+func fakeMongoDB(auth bool) func(net.Conn) {
+	return func(conn net.Conn) {
+		for {
+			var header [16]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			body := make([]byte, binary.LittleEndian.Uint32(header[:])-16)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+
+			var op uint32
+			var cmd []byte
+			switch binary.LittleEndian.Uint32(header[12:]) {
+			case mongoOpQuery:
+				// flags, collection name, numberToSkip, numberToReturn
+				name := bytes.IndexByte(body[4:], 0)
+				cmd = body[4+name+1+8:]
+				op = mongoOpReply
+			case mongoOpMsg:
+				cmd = body[5:]
+				op = mongoOpMsg
+			default:
+				return
+			}
+			// The command name is the first field of the document.
+			name := string(cmd[5 : 5+bytes.IndexByte(cmd[5:], 0)])
+
+			var doc []byte
+			switch {
+			case name == "isMaster" && op == mongoOpReply:
+				doc = bsonDoc("ismaster", true, "maxWireVersion", int32(21), "ok", 1.0)
+			case op == mongoOpReply:
+				return
+			case name == "buildInfo":
+				doc = bsonDoc("version", "7.0.2", "ok", 1.0)
+			case name == "listDatabases" && auth:
+				doc = bsonDoc("ok", 0.0, "errmsg", "command listDatabases requires authentication", "code", int32(13))
+			case name == "listDatabases":
+				doc = bsonDoc("totalSize", 8192.0, "ok", 1.0)
+			default:
+				doc = bsonDoc("ok", 0.0)
+			}
+
+			var reply bytes.Buffer
+			reply.Write(make([]byte, 16))
+			if op == mongoOpMsg {
+				reply.Write([]byte{0, 0, 0, 0, 0})
+			} else {
+				reply.Write(make([]byte, 4+8+4))
+				binary.Write(&reply, binary.LittleEndian, int32(1))
+			}
+			reply.Write(doc)
+			b := reply.Bytes()
+			binary.LittleEndian.PutUint32(b, uint32(len(b)))
+			copy(b[8:12], header[4:8]) // responseTo
+			binary.LittleEndian.PutUint32(b[12:], op)
+			conn.Write(b)
+		}
+	}
+}
+
+func TestCheckMongoDB(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "open", check: checkMongoDB, handle: fakeMongoDB(false), finding: "mongodb-unauthenticated", version: "7.0.2"},
+		{name: "auth required", check: checkMongoDB, handle: fakeMongoDB(true), finding: "mongodb-auth-required", version: "7.0.2"},
+	})
+}
+
+func TestCheckElasticsearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		finding string
+		version string
+	}{
+		{
+			name: "open",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"name":"node-1","cluster_name":"docker-cluster","version":{"number":"8.10.2"},"tagline":"You Know, for Search"}`)
+			},
+			finding: "elasticsearch-unauthenticated",
+			version: "8.10.2",
+		},
+		{
+			name: "auth required",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="security"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			finding: "elasticsearch-auth-required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			r := &Result{Host: "127.0.0.1", Port: srv.Listener.Addr().(*net.TCPAddr).Port, Protocol: protoTCP, Open: true, State: stateOpen}
+			checkElasticsearch(r)
+			if len(r.Findings) != 1 || r.Findings[0].ID != tt.finding {
+				t.Fatalf("findings = %+v, want %s", r.Findings, tt.finding)
+			}
+			if r.Version != tt.version {
+				t.Errorf("version = %q, want %q", r.Version, tt.version)
+			}
+		})
+	}
+}
+
+// fakePostgreSQL answers a startup message with AuthenticationOk and
+// ReadyForQuery when trust is set, and otherwise asks for an MD5 password.
+func fakePostgreSQL(trust bool) func(net.Conn) {
+	message := func(typ byte, body []byte) []byte {
+		b := []byte{typ, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(4+len(body)))
+		return append(b, body...)
+	}
+	return func(conn net.Conn) {
+		var length [4]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(length[:])-4)); err != nil {
+			return
+		}
+		if !trust {
+			conn.Write(message('R', []byte{0, 0, 0, 5, 1, 2, 3, 4}))
+			return
+		}
+		var b []byte
+		b = append(b, message('R', []byte{0, 0, 0, 0})...)
+		b = append(b, message('S', []byte("server_version\x0016.1\x00"))...)
+		b = append(b, message('Z', []byte{'I'})...)
+		conn.Write(b)
+	}
+}
+
+func TestCheckPostgreSQL(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "trust", check: checkPostgreSQL, handle: fakePostgreSQL(true), finding: "postgresql-trust-auth", version: "16.1"},
+		{name: "auth required", check: checkPostgreSQL, handle: fakePostgreSQL(false), finding: "postgresql-auth-required"},
+	})
+}
+
+// fakeMySQL sends the handshake MySQL greets clients with or, when the
+// client's host is not allowed, an error packet.
+func fakeMySQL(allowed bool) func(net.Conn) {
+	return func(conn net.Conn) {
+		payload := append([]byte{10}, "8.0.35\x00"...)
+		payload = append(payload, 1, 0, 0, 0, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 0)
+		if !allowed {
+			payload = append([]byte{0xff, 0x6a, 0x04}, "Host '10.0.0.1' is not allowed to connect to this MySQL server"...)
+		}
+		header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}
+		conn.Write(append(header, payload...))
+	}
+}
+
+func TestCheckMySQL(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "handshake", check: checkMySQL, handle: fakeMySQL(true), finding: "mysql-version-disclosed", version: "8.0.35"},
+		{name: "host not allowed", check: checkMySQL, handle: fakeMySQL(false)},
+	})
+}
+
+// fakeZooKeeper answers srvr, unless four-letter words are restricted to a
+// whitelist that leaves it out.
+func fakeZooKeeper(whitelisted bool) func(net.Conn) {
+	return func(conn net.Conn) {
+		cmd := make([]byte, 4)
+		if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "srvr" {
+			return
+		}
+		if !whitelisted {
+			io.WriteString(conn, "srvr is not executed because it is not in the whitelist.\n")
+			return
+		}
+		io.WriteString(conn, "Zookeeper version: 3.9.1-a34f1e6, built on 2023-10-23 17:27 UTC\n"+
+			"Latency min/avg/max: 0/0.0/0\nReceived: 3\nSent: 2\nConnections: 1\nOutstanding: 0\n"+
+			"Zxid: 0x0\nMode: standalone\nNode count: 5\n")
+	}
+}
+
+func TestCheckZooKeeper(t *testing.T) {
+	runDatastoreTests(t, []datastoreTest{
+		{name: "open", check: checkZooKeeper, handle: fakeZooKeeper(true), finding: "zookeeper-unauthenticated", version: "3.9.1"},
+		{name: "not whitelisted", check: checkZooKeeper, handle: fakeZooKeeper(false)},
+	})
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runDiff implements the diff subcommand, which compares two result files
+// written by the JSON or CSV sinks. It returns the process exit code: 0 when
+// nothing changed, 1 when something did and 2 when the comparison failed.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format (text or json).")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: portscan diff [-format text|json] old.json|old.csv new.json|new.csv")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	oldResults, err := loadResults(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Failed to load %s: %s\n", fs.Arg(0), err)
+		return 2
+	}
+	newResults, err := loadResults(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("Failed to load %s: %s\n", fs.Arg(1), err)
+		return 2
+	}
+
+	d := diffResults(oldResults, newResults)
+
+	switch *format {
+	case "text":
+		d.print(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			fmt.Printf("Failed to encode diff: %s\n", err)
+			return 2
+		}
+	default:
+		fmt.Printf("Unknown output format %q\n", *format)
+		return 2
+	}
+
+	if d.Changes > 0 {
+		return 1
+	}
+	return 0
+}
+
+// scanDiff lists what changed between two scans.
+type scanDiff struct {
+	Changes int        `json:"changes"`
+	Hosts   []hostDiff `json:"hosts"`
+}
+
+type hostDiff struct {
+	Host           string          `json:"host"`
+	Opened         []portRef       `json:"opened,omitempty"`
+	Closed         []portRef       `json:"closed,omitempty"`
+	StateChanges   []stateChange   `json:"stateChanges,omitempty"`
+	ServiceChanges []serviceChange `json:"serviceChanges,omitempty"`
+}
+
+// portRef identifies a port on a host by number and protocol, and by
+// address for hostnames scanned at several addresses.
+type portRef struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	IP       string `json:"ip,omitempty"`
+}
+
+func (p portRef) String() string {
+	if p.IP != "" {
+		return fmt.Sprintf("%d/%s (%s)", p.Port, p.Protocol, p.IP)
+	}
+	return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+}
+
+func (p portRef) less(o portRef) bool {
+	if p.Port != o.Port {
+		return p.Port < o.Port
+	}
+	if p.Protocol != o.Protocol {
+		return p.Protocol < o.Protocol
+	}
+	return p.IP < o.IP
+}
+
+type stateChange struct {
+	portRef
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// serviceChange records a change to one of the attributes returned by
+// serviceAttrs on a port that was open in both scans.
+type serviceChange struct {
+	portRef
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func (hd hostDiff) changes() int {
+	return len(hd.Opened) + len(hd.Closed) + len(hd.StateChanges) + len(hd.ServiceChanges)
+}
+
+// serviceAttrs returns the attributes of an open port that are compared
+// between scans, keyed by the name reported in the diff. An attribute that is
+// empty in either scan is not compared, since that usually means the scan
+// that produced it did not collect it. SSH host keys are compared per key
+// type, so a changed key shows up as e.g. "hostKey.ssh-ed25519".
+func serviceAttrs(r Result) map[string]string {
+	attrs := map[string]string{
+		"service": r.Service,
+		"banner":  r.Banner,
+		"product": r.Product,
+		"version": r.Version,
+	}
+	if r.SSH != nil {
+		for _, k := range r.SSH.HostKeys {
+			attrs["hostKey."+k.Type] = k.Fingerprint
+		}
+	}
+	return attrs
+}
+
+type hostPort struct {
+	host     string
+	port     int
+	protocol string
+	ip       string
+}
+
+// diffResults compares two scans. Ports missing from a scan are treated as
+// not open, since the pipeline filters closed ports out by default. Ports on
+// hostnames that either scan reached at several addresses are compared per
+// address; other hostnames are compared whatever address they were found
+// on, so that a name moving to a new address is not reported as a change.
+func diffResults(oldResults, newResults []Result) scanDiff {
+	multiHomed := multiHomedHosts(oldResults, newResults)
+	index := func(results []Result) map[hostPort]Result {
+		m := make(map[hostPort]Result, len(results))
+		for _, r := range results {
+			k := hostPort{host: r.Host, port: r.Port, protocol: r.Protocol}
+			if multiHomed[r.Host] {
+				k.ip = r.IP
+			}
+			m[k] = r
+		}
+		return m
+	}
+	before, after := index(oldResults), index(newResults)
+
+	keys := make(map[hostPort]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	hosts := make(map[string]*hostDiff)
+	for k := range keys {
+		hd, ok := hosts[k.host]
+		if !ok {
+			hd = &hostDiff{Host: k.host}
+			hosts[k.host] = hd
+		}
+
+		ref := portRef{k.port, k.protocol, k.ip}
+		o, inOld := before[k]
+		n, inNew := after[k]
+		wasOpen := inOld && o.State == stateOpen
+		isOpen := inNew && n.State == stateOpen
+
+		switch {
+		case !wasOpen && isOpen && (!inOld || o.State == stateClosed):
+			hd.Opened = append(hd.Opened, ref)
+		case wasOpen && !isOpen && (!inNew || n.State == stateClosed):
+			hd.Closed = append(hd.Closed, ref)
+		case inOld && inNew && o.State != n.State:
+			hd.StateChanges = append(hd.StateChanges, stateChange{portRef: ref, From: o.State, To: n.State})
+		case wasOpen && isOpen:
+			oldAttrs, newAttrs := serviceAttrs(o), serviceAttrs(n)
+			for field, from := range oldAttrs {
+				if to := newAttrs[field]; from != "" && to != "" && to != from {
+					hd.ServiceChanges = append(hd.ServiceChanges, serviceChange{portRef: ref, Field: field, From: from, To: to})
+				}
+			}
+		case !inOld && inNew && n.State != stateClosed:
+			hd.StateChanges = append(hd.StateChanges, stateChange{portRef: ref, From: "absent", To: n.State})
+		case inOld && !inNew && o.State != stateClosed:
+			hd.StateChanges = append(hd.StateChanges, stateChange{portRef: ref, From: o.State, To: "absent"})
+		}
+	}
+
+	var d scanDiff
+	for _, hd := range hosts {
+		if hd.changes() == 0 {
+			continue
+		}
+		sort.Slice(hd.Opened, func(i, j int) bool { return hd.Opened[i].less(hd.Opened[j]) })
+		sort.Slice(hd.Closed, func(i, j int) bool { return hd.Closed[i].less(hd.Closed[j]) })
+		sort.Slice(hd.StateChanges, func(i, j int) bool { return hd.StateChanges[i].less(hd.StateChanges[j].portRef) })
+		sort.Slice(hd.ServiceChanges, func(i, j int) bool {
+			a, b := hd.ServiceChanges[i], hd.ServiceChanges[j]
+			if a.portRef != b.portRef {
+				return a.less(b.portRef)
+			}
+			return a.Field < b.Field
+		})
+		d.Changes += hd.changes()
+		d.Hosts = append(d.Hosts, *hd)
+	}
+	sort.Slice(d.Hosts, func(i, j int) bool { return d.Hosts[i].Host < d.Hosts[j].Host })
+	return d
+}
+
+func (d scanDiff) print(w io.Writer) {
+	if d.Changes == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	for _, hd := range d.Hosts {
+		fmt.Fprintln(w, hd.Host)
+		for _, p := range hd.Opened {
+			fmt.Fprintf(w, "  + %s opened\n", p)
+		}
+		for _, p := range hd.Closed {
+			fmt.Fprintf(w, "  - %s closed\n", p)
+		}
+		for _, c := range hd.StateChanges {
+			fmt.Fprintf(w, "  ~ %s %s -> %s\n", c.portRef, c.From, c.To)
+		}
+		for _, c := range hd.ServiceChanges {
+			fmt.Fprintf(w, "  * %s %s: %q -> %q\n", c.portRef, c.Field, c.From, c.To)
+		}
+	}
+	fmt.Fprintf(w, "%d changes across %d hosts\n", d.Changes, len(d.Hosts))
+}
+
+// loadResults reads a result file written by the JSON or CSV sink. The format
+// is detected from the content rather than the file name.
+func loadResults(path string) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	first, err := firstNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' {
+		var results []Result
+		if err := json.NewDecoder(br).Decode(&results); err != nil {
+			return nil, err
+		}
+		for i := range results {
+			normalize(&results[i])
+		}
+		return results, nil
+	}
+	return loadCSVResults(br)
+}
+
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// loadCSVResults reads results written by the CSV sink. Columns are looked up
+// by header name, so files from older versions of the scanner, which only
+// scanned 127.0.0.1 and had no host or state columns, are accepted too.
+func loadCSVResults(r io.Reader) ([]Result, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[h] = i
+	}
+	if _, ok := cols["port"]; !ok {
+		return nil, errors.New("CSV results have no port column")
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	var results []Result
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		port, err := strconv.Atoi(get(rec, "port"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s to a valid port number", get(rec, "port"))
+		}
+		res := Result{
+			Host:       get(rec, "host"),
+			IP:         get(rec, "ip"),
+			Hostnames:  strings.Fields(get(rec, "hostnames")),
+			Addresses:  strings.Fields(get(rec, "addresses")),
+			Port:       port,
+			Protocol:   get(rec, "protocol"),
+			State:      get(rec, "state"),
+			Service:    get(rec, "service"),
+			Banner:     get(rec, "banner"),
+			Product:    get(rec, "product"),
+			Version:    get(rec, "version"),
+			ExtraInfo:  get(rec, "extraInfo"),
+			OS:         get(rec, "os"),
+			DeviceType: get(rec, "deviceType"),
+			ScanErr:    get(rec, "scanError"),
+			ErrClass:   get(rec, "errorClass"),
+		}
+		res.Open, _ = strconv.ParseBool(get(rec, "open"))
+		res.ScanDuration, _ = time.ParseDuration(get(rec, "scanDuration"))
+		res.BannerRaw, _ = base64.StdEncoding.DecodeString(get(rec, "bannerRaw"))
+		if cpe := get(rec, "cpe"); cpe != "" {
+			res.CPE = strings.Fields(cpe)
+		}
+		if software := get(rec, "sshSoftware"); software != "" {
+			res.SSH = &sshInfo{Software: software, HostKeys: parseSSHHostKeys(get(rec, "sshHostKeys"))}
+		}
+		normalize(&res)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// normalize fills in fields that results from older versions of the scanner
+// did not record.
+func normalize(r *Result) {
+	if r.Host == "" {
+		r.Host = "127.0.0.1"
+	}
+	if r.Protocol == "" {
+		r.Protocol = protoTCP
+	}
+	if r.State == "" {
+		r.State = stateClosed
+		if r.Open {
+			r.State = stateOpen
+		}
+	}
+}
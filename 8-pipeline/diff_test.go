@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// csvRoundTrip writes results through the CSV sink and loads them back the
+// way the diff subcommand reads a previous scan.
+func csvRoundTrip(t *testing.T, results []Result) []Result {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scans.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newCSVSink(f)
+	for _, r := range results {
+		if err := sink.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadResults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestDiffResultsPerAddress(t *testing.T) {
+	port := func(ip, state string) Result {
+		return Result{
+			Host:      "www.example.com",
+			IP:        ip,
+			Hostnames: []string{"www.example.com"},
+			Addresses: []string{"192.0.2.1", "192.0.2.2"},
+			Port:      443,
+			Protocol:  protoTCP,
+			State:     state,
+			Open:      state == stateOpen,
+		}
+	}
+	tests := []struct {
+		name     string
+		old, new []Result
+		opened   []portRef
+		closed   []portRef
+	}{
+		{
+			name:   "opened on one of two addresses",
+			old:    []Result{port("192.0.2.1", stateOpen), port("192.0.2.2", stateClosed)},
+			new:    []Result{port("192.0.2.1", stateOpen), port("192.0.2.2", stateOpen)},
+			opened: []portRef{{443, protoTCP, "192.0.2.2"}},
+		},
+		{
+			name:   "closed on one of two addresses",
+			old:    []Result{port("192.0.2.1", stateOpen), port("192.0.2.2", stateOpen)},
+			new:    []Result{port("192.0.2.1", stateOpen)},
+			closed: []portRef{{443, protoTCP, "192.0.2.2"}},
+		},
+		{
+			name: "single address moved",
+			old:  []Result{port("192.0.2.1", stateOpen)},
+			new:  []Result{port("192.0.2.9", stateOpen)},
+		},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"memory", "csv"} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				old, new := tt.old, tt.new
+				if format == "csv" {
+					old, new = csvRoundTrip(t, old), csvRoundTrip(t, new)
+					for i, r := range old {
+						want := tt.old[i]
+						if r.IP != want.IP || !reflect.DeepEqual(r.Hostnames, want.Hostnames) || !reflect.DeepEqual(r.Addresses, want.Addresses) {
+							t.Errorf("loaded %s %v %v, want %s %v %v", r.IP, r.Hostnames, r.Addresses, want.IP, want.Hostnames, want.Addresses)
+						}
+					}
+				}
+				d := diffResults(old, new)
+				var opened, closed []portRef
+				for _, hd := range d.Hosts {
+					opened = append(opened, hd.Opened...)
+					closed = append(closed, hd.Closed...)
+				}
+				if !reflect.DeepEqual(opened, tt.opened) {
+					t.Errorf("opened = %v, want %v", opened, tt.opened)
+				}
+				if !reflect.DeepEqual(closed, tt.closed) {
+					t.Errorf("closed = %v, want %v", closed, tt.closed)
+				}
+			})
+		}
+	}
+}
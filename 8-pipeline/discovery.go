@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var discoverHosts bool
+var discoveryPorts string
+var discoveryTimeout time.Duration
+
+func init() {
+	flag.BoolVar(&discoverHosts, "discover", false, "Check which hosts are up before scanning them, and skip the rest.")
+	flag.StringVar(&discoveryPorts, "discovery-ports", "22,80,443,445,3389", "TCP ports -discover connects to when checking whether a host is up.")
+	flag.DurationVar(&discoveryTimeout, "discovery-timeout", 2*time.Second, "Time to wait for any discovery port to answer before deciding a host is down.")
+}
+
+// Reasons a host was found up or down, following the vocabulary used by nmap.
+const (
+	reasonSynAck      = "syn-ack"
+	reasonConnRefused = "conn-refused"
+	reasonHostUnreach = "host-unreach"
+	reasonResolve     = "resolve-failed"
+	reasonNoResponse  = "no-response"
+)
+
+// hostStatus is the outcome of host discovery for one target.
+type hostStatus struct {
+	Host   string `json:"host"`
+	IP     string `json:"ip,omitempty"`
+	Up     bool   `json:"up"`
+	Reason string `json:"reason"`
+	// Port is the discovery port that answered, if any.
+	Port    int           `json:"port,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+func (hs hostStatus) state() string {
+	if hs.Up {
+		return "up"
+	}
+	return "down"
+}
+
+// parseDiscoveryPorts parses -discovery-ports, which only takes TCP ports.
+func parseDiscoveryPorts(spec string) ([]int, error) {
+	ps, err := parsePortSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps.udp) > 0 {
+		return nil, errors.New("host discovery only connects to TCP ports")
+	}
+	return ps.tcp, nil
+}
+
+// discover checks which targets are up, n at a time, and returns their
+// statuses in the order of targets.
+func discover(targets []target, ports []int, n int) []hostStatus {
+	statuses := make([]hostStatus, len(targets))
+	indexes := make(chan int)
+	done := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			for i := range indexes {
+				statuses[i] = ping(targets[i], ports)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	return statuses
+}
+
+// ping connects to every discovery port on t at once. The host is up as
+// soon as one of them answers, whether by accepting the connection or by
+// refusing it: either way something is there to reply.
+func ping(t target, ports []int) hostStatus {
+	type answer struct {
+		port    int
+		class   string
+		latency time.Duration
+	}
+	answers := make(chan answer, len(ports))
+	start := time.Now()
+	for _, p := range ports {
+		go func(p int) {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(t.dialHost(), strconv.Itoa(p)), discoveryTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			answers <- answer{port: p, class: errorClass(err), latency: time.Since(start)}
+		}(p)
+	}
+
+	status := hostStatus{Host: t.host, IP: t.ip, Reason: reasonNoResponse}
+	unanswered := make(map[string]int)
+	for range ports {
+		a := <-answers
+		switch a.class {
+		case "":
+			status.Reason = reasonSynAck
+		case "refused", "reset":
+			status.Reason = reasonConnRefused
+		default:
+			unanswered[a.class]++
+			continue
+		}
+		status.Up = true
+		status.Port = a.port
+		status.Latency = a.latency
+		return status
+	}
+	switch {
+	case unanswered["unreachable"] == len(ports):
+		status.Reason = reasonHostUnreach
+	case unanswered["dns"] == len(ports):
+		status.Reason = reasonResolve
+	}
+	return status
+}
+
+// liveTargets returns the targets discovery found up.
+func liveTargets(statuses []hostStatus) []target {
+	var targets []target
+	for _, hs := range statuses {
+		if hs.Up {
+			targets = append(targets, target{host: hs.Host, ip: hs.IP})
+		}
+	}
+	return targets
+}
+
+// printDiscovery prints the outcome of host discovery, ahead of the port
+// scan's own output.
+func printDiscovery(w io.Writer, statuses []hostStatus) error {
+	fmt.Fprintln(w, "Host discovery\n--------------")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATE\tREASON\tLATENCY")
+	for _, hs := range statuses {
+		reason, lat := hs.Reason, ""
+		if hs.Up {
+			reason += " " + strconv.Itoa(hs.Port) + "/" + protoTCP
+			lat = latency(hs.Latency)
+		}
+		host := hs.Host
+		if hs.IP != "" {
+			host += " (" + hs.IP + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", host, hs.state(), reason, lat)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d of %d hosts up\n\n", len(liveTargets(statuses)), len(statuses))
+	return err
+}
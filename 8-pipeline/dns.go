@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dnsProbe bool
+var dnsRecursionName string
+
+func init() {
+	flag.BoolVar(&dnsProbe, "dns", false, "Query DNS servers on TCP and UDP port 53 for their version and whether they recurse.")
+	flag.StringVar(&dnsRecursionName, "dns-recursion-name", "example.com", "Name to look up when testing whether a DNS server recurses for anyone.")
+}
+
+// dnsInfo is what a DNS server revealed about itself.
+type dnsInfo struct {
+	// Version is the server's answer to a version.bind CHAOS TXT query.
+	Version string `json:"version,omitempty"`
+	// Authoritative is set when the server answered the recursion test
+	// name from its own zones.
+	Authoritative      bool   `json:"authoritative"`
+	RecursionAvailable bool   `json:"recursionAvailable"`
+	Recurses           bool   `json:"recurses"`
+	ResponseCode       string `json:"responseCode"`
+}
+
+// DNS types and classes used by the probe.
+const (
+	dnsTypeA   = 1
+	dnsTypeNS  = 2
+	dnsTypeTXT = 16
+
+	dnsClassIN = 1
+	dnsClassCH = 3
+)
+
+// Bits of the second header word (RFC 1035, section 4.1.1).
+const (
+	dnsFlagQR = 0x8000
+	dnsFlagAA = 0x0400
+	dnsFlagRD = 0x0100
+	dnsFlagRA = 0x0080
+)
+
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+// wantDNS selects port 53, and ports identified as DNS, over either
+// protocol. UDP ports that stayed silent are tried too, since they may only
+// have ignored the scan's query.
+func wantDNS(r Result) bool {
+	if r.Port != 53 && r.Service != "domain" {
+		return false
+	}
+	return r.Open || r.State == stateOpenFiltered
+}
+
+// probeDNS asks the server for its version and looks up
+// dnsRecursionName with recursion desired. A server that answers the
+// lookup without being authoritative for it recurses for anyone who can
+// reach it, which is what makes an open resolver.
+func probeDNS(r *Result) {
+	resp, err := dnsExchange(r, dnsQuestion(0x5ca1, dnsRecursionName, dnsTypeA, dnsClassIN, true))
+	if err != nil {
+		return
+	}
+	info := &dnsInfo{
+		Authoritative:      resp.flags&dnsFlagAA != 0,
+		RecursionAvailable: resp.flags&dnsFlagRA != 0,
+		ResponseCode:       resp.rcode(),
+	}
+	info.Recurses = !info.Authoritative && info.RecursionAvailable && resp.rcode() == "NOERROR" && len(resp.answers) > 0
+
+	if resp, err := dnsExchange(r, dnsQuestion(0x5ca2, "version.bind", dnsTypeTXT, dnsClassCH, false)); err == nil {
+		for _, a := range resp.answers {
+			if a.typ == dnsTypeTXT {
+				info.Version = strings.Join(dnsTXT(a.data), " ")
+				break
+			}
+		}
+	}
+
+	r.DNS = info
+	r.Service = "domain"
+	if r.State == stateOpenFiltered {
+		r.Open = true
+		r.State = stateOpen
+	}
+	if info.Recurses {
+		r.addFinding("dns-open-resolver", severityHigh, "DNS server recurses for arbitrary clients", "resolved "+dnsRecursionName)
+	}
+	if info.Version != "" {
+		r.addFinding("dns-version-disclosure", severityLow, "DNS server discloses its version", info.Version)
+	}
+}
+
+// dnsQuestion builds a query for a single name, type and class.
+func dnsQuestion(id uint16, name string, typ, class uint16, recursionDesired bool) []byte {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	if recursionDesired {
+		binary.BigEndian.PutUint16(msg[2:], dnsFlagRD)
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	var tail [4]byte
+	binary.BigEndian.PutUint16(tail[0:], typ)
+	binary.BigEndian.PutUint16(tail[2:], class)
+	return append(msg, tail[:]...)
+}
+
+type dnsResponse struct {
+	id      uint16
+	flags   uint16
+	answers []dnsRecord
+}
+
+type dnsRecord struct {
+	typ  uint16
+	data []byte
+}
+
+func (m dnsResponse) rcode() string {
+	if rc := int(m.flags & 0xf); rc < len(dnsRcodes) {
+		return dnsRcodes[rc]
+	}
+	return "RCODE" + strconv.Itoa(int(m.flags&0xf))
+}
+
+// dnsExchange sends query over the result's protocol, with the two-byte
+// length prefix DNS uses over TCP, and parses the response.
+func dnsExchange(r *Result, query []byte) (*dnsResponse, error) {
+	conn, err := net.DialTimeout(r.Protocol, r.address(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var reply []byte
+	if r.Protocol == protoTCP {
+		var n [2]byte
+		binary.BigEndian.PutUint16(n[:], uint16(len(query)))
+		if _, err := conn.Write(append(n[:], query...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, err
+		}
+		reply = make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		reply = make([]byte, 4096)
+		n, err := conn.Read(reply)
+		if err != nil {
+			return nil, err
+		}
+		reply = reply[:n]
+	}
+
+	resp, err := parseDNSResponse(reply)
+	if err != nil {
+		return nil, err
+	}
+	if resp.id != binary.BigEndian.Uint16(query) {
+		return nil, errors.New("DNS response ID does not match the query")
+	}
+	return resp, nil
+}
+
+var errDNS = errors.New("malformed DNS response")
+
+// parseDNSResponse reads the header and answer records of a response.
+func parseDNSResponse(b []byte) (*dnsResponse, error) {
+	if len(b) < 12 {
+		return nil, errDNS
+	}
+	resp := &dnsResponse{
+		id:    binary.BigEndian.Uint16(b[0:]),
+		flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	if resp.flags&dnsFlagQR == 0 {
+		return nil, errDNS
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var err error
+		if off, err = skipDNSName(b, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	for i := 0; i < ancount; i++ {
+		var err error
+		if off, err = skipDNSName(b, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(b) {
+			return nil, errDNS
+		}
+		typ := binary.BigEndian.Uint16(b[off:])
+		rdlen := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+rdlen > len(b) {
+			return nil, errDNS
+		}
+		resp.answers = append(resp.answers, dnsRecord{typ: typ, data: b[off : off+rdlen]})
+		off += rdlen
+	}
+	return resp, nil
+}
+
+// skipDNSName returns the offset just past the name starting at off, which
+// may end in a compression pointer.
+func skipDNSName(b []byte, off int) (int, error) {
+	for off < len(b) {
+		l := int(b[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		}
+		off += 1 + l
+	}
+	return 0, errDNS
+}
+
+// dnsTXT splits TXT record data into its character strings.
+func dnsTXT(data []byte) []string {
+	var txt []string
+	for len(data) > 0 {
+		n := int(data[0])
+		if 1+n > len(data) {
+			break
+		}
+		txt = append(txt, string(data[1:1+n]))
+		data = data[1+n:]
+	}
+	return txt
+}
+
+// dnsColumns returns the CSV columns describing the DNS server.
+func (r Result) dnsColumns() []string {
+	d := r.DNS
+	if d == nil {
+		return make([]string, 3)
+	}
+	return []string{d.Version, d.ResponseCode, strings.Join(d.flags(), " ")}
+}
+
+// flags lists the notable properties of the server's answer to the
+// recursion test.
+func (d *dnsInfo) flags() []string {
+	var flags []string
+	if d.Authoritative {
+		flags = append(flags, "authoritative")
+	}
+	if d.RecursionAvailable {
+		flags = append(flags, "recursion-available")
+	}
+	if d.Recurses {
+		flags = append(flags, "open-resolver")
+	}
+	return flags
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeDNS describes how a fake DNS server answers the probe's queries.
+type fakeDNS struct {
+	version       string // answer to version.bind, or "" to refuse it
+	authoritative bool   // serves the recursion test name from its zones
+	recursion     bool   // advertises recursion
+	recurses      bool   // resolves names it is not authoritative for
+}
+
+// answer builds the server's response to query.
+func (s fakeDNS) answer(query []byte) []byte {
+	off := 12
+	for query[off] != 0 {
+		off += 1 + int(query[off])
+	}
+	question := query[12 : off+5]
+	class := binary.BigEndian.Uint16(query[off+3:])
+
+	flags := uint16(dnsFlagQR) | binary.BigEndian.Uint16(query[2:])&dnsFlagRD
+	if s.recursion {
+		flags |= dnsFlagRA
+	}
+	var rtype uint16
+	var rdata []byte
+	switch {
+	case class == dnsClassCH && s.version != "":
+		flags |= dnsFlagAA
+		rtype, rdata = dnsTypeTXT, append([]byte{byte(len(s.version))}, s.version...)
+	case class == dnsClassCH:
+		flags |= 5 // REFUSED
+	case s.authoritative:
+		flags |= dnsFlagAA
+		rtype, rdata = dnsTypeA, []byte{192, 0, 2, 1}
+	case s.recurses:
+		rtype, rdata = dnsTypeA, []byte{93, 184, 216, 34}
+	default:
+		flags |= 5 // REFUSED
+	}
+
+	msg := make([]byte, 12)
+	copy(msg, query[:2])
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = append(msg, question...)
+	if rdata != nil {
+		binary.BigEndian.PutUint16(msg[6:], 1)
+		rr := make([]byte, 12)
+		binary.BigEndian.PutUint16(rr[0:], 0xc00c) // pointer to the question name
+		binary.BigEndian.PutUint16(rr[2:], rtype)
+		binary.BigEndian.PutUint16(rr[4:], class)
+		binary.BigEndian.PutUint32(rr[6:], 300)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		msg = append(append(msg, rr...), rdata...)
+	}
+	return msg
+}
+
+// serveUDP answers queries on a local UDP port and returns a result for the
+// port as a UDP scan leaves it, open|filtered.
+func (s fakeDNS) serveUDP(t *testing.T) *Result {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(s.answer(buf[:n]), addr)
+		}
+	}()
+	return &Result{Host: "127.0.0.1", Port: pc.LocalAddr().(*net.UDPAddr).Port, Protocol: protoUDP, State: stateOpenFiltered}
+}
+
+// serveTCP answers length-prefixed queries on a local TCP port.
+func (s fakeDNS) serveTCP(t *testing.T) *Result {
+	t.Helper()
+	return fakeServer(t, func(conn net.Conn) {
+		for {
+			var n [2]byte
+			if _, err := io.ReadFull(conn, n[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(n[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			resp := s.answer(query)
+			binary.BigEndian.PutUint16(n[:], uint16(len(resp)))
+			conn.Write(append(n[:], resp...))
+		}
+	})
+}
+
+func TestProbeDNS(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   fakeDNS
+		want     dnsInfo
+		findings []string
+	}{
+		{
+			name:     "open resolver",
+			server:   fakeDNS{version: "9.18.19", recursion: true, recurses: true},
+			want:     dnsInfo{Version: "9.18.19", RecursionAvailable: true, Recurses: true, ResponseCode: "NOERROR"},
+			findings: []string{"dns-open-resolver", "dns-version-disclosure"},
+		},
+		{
+			name:   "authoritative",
+			server: fakeDNS{authoritative: true},
+			want:   dnsInfo{Authoritative: true, ResponseCode: "NOERROR"},
+		},
+		{
+			name:   "authoritative with recursion",
+			server: fakeDNS{authoritative: true, recursion: true, recurses: true},
+			want:   dnsInfo{Authoritative: true, RecursionAvailable: true, ResponseCode: "NOERROR"},
+		},
+		{
+			name:     "recursion refused",
+			server:   fakeDNS{version: "unbound 1.17.1", recursion: true},
+			want:     dnsInfo{Version: "unbound 1.17.1", RecursionAvailable: true, ResponseCode: "REFUSED"},
+			findings: []string{"dns-version-disclosure"},
+		},
+	}
+	for _, tt := range tests {
+		for _, proto := range []string{protoUDP, protoTCP} {
+			t.Run(tt.name+"/"+proto, func(t *testing.T) {
+				serve := tt.server.serveUDP
+				if proto == protoTCP {
+					serve = tt.server.serveTCP
+				}
+				r := serve(t)
+				probeDNS(r)
+				if r.DNS == nil {
+					t.Fatal("no DNS info recorded")
+				}
+				if *r.DNS != tt.want {
+					t.Errorf("DNS info = %+v, want %+v", *r.DNS, tt.want)
+				}
+				var ids []string
+				for _, f := range r.Findings {
+					ids = append(ids, f.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.findings) {
+					t.Errorf("findings = %v, want %v", ids, tt.findings)
+				}
+				if !r.Open || r.State != stateOpen {
+					t.Errorf("port left %s, want open", r.State)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var dryRun bool
+
+func init() {
+	flag.BoolVar(&dryRun, "dry-run", false, "Print what the scan would do, and how long it might take, without sending anything.")
+}
+
+// fastAnswer is how quickly a port is assumed to answer at best, as on a
+// local network, when estimating how long a scan takes.
+const fastAnswer = time.Millisecond
+
+// scanPlan is what a scan would do with the current flags.
+type scanPlan struct {
+	targets []target
+	ports   portSpec
+	// excluded counts the targets left out by the scope, and otherFamily
+	// the addresses left out by -4 or -6.
+	excluded    int
+	otherFamily int
+	// pingPorts are the host discovery ports, or nil without -discover.
+	pingPorts []int
+}
+
+// newScanPlan applies the scope to hosts without resolving any hostname, so
+// that nothing is sent. Hostnames are only excluded by name; their
+// addresses are checked against the scope when the scan resolves them.
+func newScanPlan(hosts []string, ports portSpec, sc *scope) (*scanPlan, error) {
+	var addrs, names []target
+	var otherFamily int
+	for _, h := range hosts {
+		ip, _ := parseIPZone(h)
+		switch {
+		case ip == nil:
+			names = append(names, target{host: h})
+		case inFamily(ip):
+			addrs = append(addrs, target{host: h})
+		default:
+			otherFamily++
+		}
+	}
+	targets, excluded, err := sc.apply(addrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range names {
+		if sc.excluded.contains(t) {
+			excluded++
+			continue
+		}
+		targets = append(targets, t)
+	}
+
+	p := &scanPlan{targets: targets, excluded: excluded, otherFamily: otherFamily, ports: ports}
+	if discoverHosts {
+		if p.pingPorts, err = parseDiscoveryPorts(discoveryPorts); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *scanPlan) probes() int {
+	return len(p.targets) * p.ports.count()
+}
+
+// estimate returns how long the scan could take, from every port answering
+// straight away to every port timing out. It leaves out the probes run
+// against open ports, whose number is not known in advance.
+func (p *scanPlan) estimate() (fastest, slowest time.Duration) {
+	rounds := func(n int) time.Duration {
+		return time.Duration((n + workers - 1) / workers)
+	}
+	fastest = rounds(p.probes()) * fastAnswer
+	slowest = rounds(p.probes()) * timeout
+	if p.pingPorts != nil {
+		fastest += rounds(len(p.targets)) * fastAnswer
+		slowest += rounds(len(p.targets)) * discoveryTimeout
+	}
+	return fastest, slowest
+}
+
+// print writes the plan: the settings the scan would run with and the ports
+// it would scan on each host.
+func (p *scanPlan) print(w io.Writer) error {
+	fmt.Fprintln(w, "Scan plan\n--------------")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var left []string
+	if p.excluded > 0 {
+		left = append(left, fmt.Sprintf("%d excluded", p.excluded))
+	}
+	if p.otherFamily > 0 {
+		left = append(left, fmt.Sprintf("%d not %s", p.otherFamily, familyName()))
+	}
+	hosts := fmt.Sprintf("%d", len(p.targets))
+	if len(left) > 0 {
+		hosts += " (" + strings.Join(left, ", ") + ")"
+	}
+	fmt.Fprintf(tw, "Targets:\t%s\n", hosts)
+	fmt.Fprintf(tw, "Ports:\t%s (%d per host)\n", p.ports, p.ports.count())
+	fmt.Fprintf(tw, "Probes:\t%d\n", p.probes())
+	if p.pingPorts != nil {
+		fmt.Fprintf(tw, "Discovery:\tTCP connect to %s, %s timeout\n", compactPorts(p.pingPorts), discoveryTimeout)
+	} else {
+		fmt.Fprintf(tw, "Discovery:\toff (enable with -discover)\n")
+	}
+	fmt.Fprintf(tw, "Strategy:\t%s\n", strings.Join(p.strategy(), ", "))
+	if stages := probeStages(); len(stages) > 0 {
+		fmt.Fprintf(tw, "Open port probes:\t%s\n", strings.Join(stages, ", "))
+	}
+	fmt.Fprintf(tw, "Concurrency:\t%d\n", workers)
+	fmt.Fprintf(tw, "Rate:\tunlimited beyond concurrency\n")
+	fmt.Fprintf(tw, "Timeout:\t%s per connection\n", timeout)
+	fastest, slowest := p.estimate()
+	fmt.Fprintf(tw, "Estimate:\t%s to %s\n", fastest.Round(time.Millisecond), slowest.Round(time.Second))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nHosts\n--------------")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tPORTS")
+	for _, t := range p.targets {
+		host := t.host
+		if ip, _ := parseIPZone(t.host); ip == nil {
+			host += " (resolved when scanning)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", host, p.ports)
+	}
+	if len(p.targets) == 0 {
+		fmt.Fprintln(tw, "no hosts to scan")
+	}
+	return tw.Flush()
+}
+
+// strategy describes how ports are scanned.
+func (p *scanPlan) strategy() []string {
+	var s []string
+	if len(p.ports.tcp) > 0 {
+		s = append(s, "TCP connect")
+	}
+	if len(p.ports.udp) > 0 {
+		s = append(s, "UDP payloads")
+	}
+	switch {
+	case ipv4Only:
+		s = append(s, "IPv4 only")
+	case ipv6Only:
+		s = append(s, "IPv6 only")
+	}
+	if allAddresses {
+		s = append(s, "every address of each hostname")
+	}
+	return s
+}
+
+// probeStages lists the probes that would run against open ports, in the
+// order probe runs them.
+func probeStages() []string {
+	var stages []string
+	add := func(enabled bool, name string) {
+		if enabled {
+			stages = append(stages, name)
+		}
+	}
+	add(resolveNames, "resolve")
+	add(bannerGrab, "banner")
+	add(tlsProbe || tlsEnum || detectProtocols, "tls")
+	add(tlsEnum, "tls-enum")
+	add(serviceProbesFile != "", "service-probes")
+	add(httpProbe || httpAudit || detectProtocols, "http")
+	add(httpAudit, "http-audit")
+	add(detectProtocols, "detect-protocols")
+	add(sshProbe, "ssh")
+	add(capabilityProbe || ftpAnonymous, "capabilities")
+	add(dnsProbe, "dns")
+	add(datastoreChecks, "datastores")
+	add(scriptsDir != "", "scripts")
+	add(pluginsDir != "", "plugins")
+	return stages
+}
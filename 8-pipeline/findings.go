@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// finding is a problem discovered on an open port by one of the probes.
+type finding struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+
+	// Data holds structured details, such as the version a datastore
+	// reported.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Finding severities, from least to most severe.
+const (
+	severityInfo     = "info"
+	severityLow      = "low"
+	severityMedium   = "medium"
+	severityHigh     = "high"
+	severityCritical = "critical"
+)
+
+var severityRank = map[string]int{
+	severityInfo:     0,
+	severityLow:      1,
+	severityMedium:   2,
+	severityHigh:     3,
+	severityCritical: 4,
+}
+
+// addFinding records a finding on the result.
+func (r *Result) addFinding(id, severity, title, detail string) {
+	r.Findings = append(r.Findings, finding{ID: id, Severity: severity, Title: title, Detail: detail})
+}
+
+// sortFindings orders findings from most to least severe.
+func sortFindings(fs []finding) {
+	sort.SliceStable(fs, func(i, j int) bool {
+		return severityRank[fs[i].Severity] > severityRank[fs[j].Severity]
+	})
+}
+
+// findingsColumn returns the CSV column listing the result's findings as
+// severity:id pairs.
+func (r Result) findingsColumn() string {
+	parts := make([]string, len(r.Findings))
+	for i, f := range r.Findings {
+		parts[i] = f.Severity + ":" + f.ID
+	}
+	return strings.Join(parts, " ")
+}
+
+// junitSink writes a JUnit XML report once the scan is over, with one test
+// suite per host. Every finding is a failed test case and every open port
+// without findings a passing one, so CI dashboards can track them.
+type junitSink struct {
+	w       io.WriteCloser
+	results []Result
+}
+
+func newJUnitSink(w io.WriteCloser) *junitSink {
+	return &junitSink{w: w}
+}
+
+func (s *junitSink) Write(r Result) error {
+	if r.Open {
+		s.results = append(s.results, r)
+	}
+	return nil
+}
+
+func (s *junitSink) Close() error {
+	sort.Slice(s.results, func(i, j int) bool {
+		a, b := s.results[i], s.results[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.IP < b.IP
+	})
+
+	// Ports on hosts scanned at several addresses are named after the
+	// address too, so that each address has its own test cases.
+	multiHomed := multiHomedHosts(s.results)
+	var checks []check
+	for _, r := range s.results {
+		var ip string
+		if multiHomed[r.Host] {
+			ip = r.IP
+		}
+		if len(r.Findings) == 0 {
+			checks = append(checks, check{Host: r.Host, IP: ip, Port: r.Port, Expect: "without findings", Got: r.State})
+			continue
+		}
+		for _, f := range r.Findings {
+			msg := f.Title
+			if f.Detail != "" {
+				msg += ": " + f.Detail
+			}
+			checks = append(checks, check{
+				Host:     r.Host,
+				IP:       ip,
+				Port:     r.Port,
+				Expect:   "without " + f.ID,
+				Got:      f.Title,
+				Failure:  msg,
+				Severity: f.Severity,
+			})
+		}
+	}
+
+	err := writeJUnit(s.w, checks, 0)
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
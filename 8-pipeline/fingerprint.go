@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var serviceProbesFile string
+var serviceProbes *probeEngine
+var versionIntensity int
+var probeWait time.Duration
+
+func init() {
+	flag.StringVar(&serviceProbesFile, "service-probes", "", "nmap-service-probes file used to identify services on open ports (disabled by default).")
+	flag.IntVar(&versionIntensity, "version-intensity", 7, "Only send service probes with a rarity up to this value (0-9).")
+	flag.DurationVar(&probeWait, "probe-wait", 5*time.Second, "Upper bound on how long to wait for a reply to each service probe.")
+}
+
+// serviceProbe is a Probe directive from an nmap-service-probes file along
+// with the directives that follow it.
+type serviceProbe struct {
+	protocol  string
+	name      string
+	payload   []byte
+	ports     portSet
+	sslports  portSet
+	totalWait time.Duration
+	rarity    int
+	fallback  []string
+	matches   []*serviceMatch
+}
+
+// serviceMatch is a match or softmatch directive.
+type serviceMatch struct {
+	service string
+	soft    bool
+	re      *regexp.Regexp
+
+	// Version info templates, which may refer to submatches with $1,
+	// $P(1), $SUBST(1,"a","b") and $I(1,">").
+	product, version, info, os, device string
+	cpes                               []string
+}
+
+// portSet is a set of ports from a directive such as "ports 21,80-90".
+type portSet map[int]bool
+
+// probeEngine identifies services using probes loaded from a file in the
+// nmap-service-probes format.
+type probeEngine struct {
+	probes  []*serviceProbe
+	byName  map[string]*serviceProbe
+	exclude portSet
+
+	// skipped counts match directives whose regular expression uses PCRE
+	// features that Go's regexp package does not support.
+	skipped int
+}
+
+func loadServiceProbes(path string) (*probeEngine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseServiceProbes(f)
+}
+
+// parseServiceProbes parses the nmap-service-probes format described at
+// https://nmap.org/book/vscan-fileformat.html.
+func parseServiceProbes(r io.Reader) (*probeEngine, error) {
+	e := &probeEngine{byName: make(map[string]*serviceProbe)}
+
+	var cur *serviceProbe
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		directive, rest := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			directive, rest = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		if directive == "Probe" {
+			p, err := parseProbeDirective(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			cur = p
+			e.probes = append(e.probes, p)
+			e.byName[p.protocol+"/"+p.name] = p
+			continue
+		}
+		if directive == "Exclude" {
+			ports, err := parseExcludeDirective(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			e.exclude = ports
+			continue
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: %s directive before any Probe", lineNo, directive)
+		}
+
+		var err error
+		switch directive {
+		case "match", "softmatch":
+			var m *serviceMatch
+			m, err = parseMatchDirective(rest, directive == "softmatch")
+			if errors.Is(err, errUnsupportedRegexp) {
+				e.skipped++
+				err = nil
+			} else if err == nil {
+				cur.matches = append(cur.matches, m)
+			}
+		case "ports":
+			cur.ports, err = parsePortSet(rest)
+		case "sslports":
+			cur.sslports, err = parsePortSet(rest)
+		case "totalwaitms":
+			var ms int
+			ms, err = strconv.Atoi(rest)
+			cur.totalWait = time.Duration(ms) * time.Millisecond
+		case "rarity":
+			cur.rarity, err = strconv.Atoi(rest)
+		case "fallback":
+			cur.fallback = strings.Split(rest, ",")
+		}
+		// Other directives, such as tcpwrappedms, do not affect how
+		// probes are sent or matched here.
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseProbeDirective parses "TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|".
+func parseProbeDirective(s string) (*serviceProbe, error) {
+	fields := strings.SplitN(s, " ", 3)
+	if len(fields) != 3 || (fields[0] != "TCP" && fields[0] != "UDP") {
+		return nil, fmt.Errorf("malformed Probe directive %q", s)
+	}
+	payload := fields[2]
+	if len(payload) < 3 || payload[0] != 'q' {
+		return nil, fmt.Errorf("malformed probe payload %q", payload)
+	}
+	end := strings.IndexByte(payload[2:], payload[1])
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated probe payload %q", payload)
+	}
+	return &serviceProbe{
+		protocol: fields[0],
+		name:     fields[1],
+		payload:  unescapePayload(payload[2 : 2+end]),
+		rarity:   1,
+	}, nil
+}
+
+// unescapePayload interprets the C-style escapes used in probe payloads.
+func unescapePayload(s string) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '0':
+			b.WriteByte(0)
+		case 'a':
+			b.WriteByte('\a')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			if i+2 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			b.WriteByte('x')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.Bytes()
+}
+
+var errUnsupportedRegexp = errors.New("unsupported regular expression")
+
+// parseMatchDirective parses the part of a match or softmatch directive
+// after the directive name, e.g. "ssh m|^SSH-([\d.]+)-OpenSSH_(\S+)| p/OpenSSH/ v/$2/".
+func parseMatchDirective(s string, soft bool) (*serviceMatch, error) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed match directive %q", s)
+	}
+	m := &serviceMatch{service: s[:i], soft: soft}
+	rest := strings.TrimLeft(s[i+1:], " ")
+
+	if len(rest) < 2 || rest[0] != 'm' {
+		return nil, fmt.Errorf("malformed match pattern %q", rest)
+	}
+	delim := rest[1]
+	end := strings.IndexByte(rest[2:], delim)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated match pattern %q", rest)
+	}
+	pattern := rest[2 : 2+end]
+	rest = rest[3+end:]
+
+	var flags string
+	for len(rest) > 0 && (rest[0] == 'i' || rest[0] == 's') {
+		flags += rest[:1]
+		rest = rest[1:]
+	}
+	re, err := compileProbeRegexp(pattern, flags)
+	if err != nil {
+		return nil, errUnsupportedRegexp
+	}
+	m.re = re
+
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return m, nil
+		}
+		var key string
+		if strings.HasPrefix(rest, "cpe:") {
+			key, rest = "cpe", rest[4:]
+		} else {
+			key, rest = rest[:1], rest[1:]
+		}
+		if rest == "" {
+			return nil, fmt.Errorf("malformed version info in %q", s)
+		}
+		delim := rest[0]
+		end := strings.IndexByte(rest[1:], delim)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated version info in %q", s)
+		}
+		val := rest[1 : 1+end]
+		rest = rest[2+end:]
+
+		switch key {
+		case "p":
+			m.product = val
+		case "v":
+			m.version = val
+		case "i":
+			m.info = val
+		case "o":
+			m.os = val
+		case "d":
+			m.device = val
+		case "cpe":
+			m.cpes = append(m.cpes, "cpe:/"+val)
+			rest = strings.TrimPrefix(rest, "a")
+		}
+	}
+}
+
+// compileProbeRegexp compiles a PCRE pattern from a probes file. Responses
+// are matched as Latin-1 so that each byte is one rune and escapes such as
+// \xff match the byte rather than a UTF-8 sequence.
+func compileProbeRegexp(pattern, flags string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if flags != "" {
+		b.WriteString("(?" + flags + ")")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c == '\\' && i+1 < len(pattern) && pattern[i+1] == '0' && (i+2 == len(pattern) || pattern[i+2] < '0' || pattern[i+2] > '7') {
+			b.WriteString(`\x00`)
+			i++
+			continue
+		}
+		b.WriteRune(rune(c))
+	}
+	return regexp.Compile(b.String())
+}
+
+func parsePortSet(s string) (portSet, error) {
+	set := make(portSet)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s to a valid port number", lo)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s to a valid port number", hi)
+		}
+		for p := from; p <= to; p++ {
+			set[p] = true
+		}
+	}
+	return set, nil
+}
+
+// parseExcludeDirective parses "Exclude T:9100-9107,U:30000-40000" and
+// returns the TCP ports it lists. As in nmap's port specifications, T: and U:
+// apply to the ranges that follow them, and ranges without either apply to
+// both protocols.
+func parseExcludeDirective(s string) (portSet, error) {
+	var tcp []string
+	protocol := ""
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.HasPrefix(part, "T:"):
+			protocol, part = "T", part[2:]
+		case strings.HasPrefix(part, "U:"):
+			protocol, part = "U", part[2:]
+		}
+		if protocol != "U" {
+			tcp = append(tcp, part)
+		}
+	}
+	return parsePortSet(strings.Join(tcp, ","))
+}
+
+// latin1 maps each byte of b to the rune with the same value.
+func latin1(b []byte) string {
+	rs := make([]rune, len(b))
+	for i, c := range b {
+		rs[i] = rune(c)
+	}
+	return string(rs)
+}
+
+// fromLatin1 reverses latin1.
+func fromLatin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// probesFor returns the probes to send to port over protocol, in the order
+// nmap sends them: the NULL probe, probes that list the port, then the rest,
+// leaving out unlisted probes rarer than intensity.
+func (e *probeEngine) probesFor(protocol string, port, intensity int) []*serviceProbe {
+	if e.exclude[port] {
+		return nil
+	}
+	var null, listed, others []*serviceProbe
+	for _, p := range e.probes {
+		switch {
+		case p.protocol != protocol:
+		case p.name == "NULL":
+			null = append(null, p)
+		case p.ports[port] || p.sslports[port]:
+			listed = append(listed, p)
+		case p.rarity > intensity:
+		default:
+			others = append(others, p)
+		}
+	}
+	return append(append(null, listed...), others...)
+}
+
+// match checks resp, the reply to p, against p's match directives and those
+// of its fallbacks. TCP replies are also checked against the NULL probe,
+// since many services send their banner regardless of what they receive.
+func (e *probeEngine) match(p *serviceProbe, resp []byte) (*serviceMatch, []string) {
+	candidates := []*serviceProbe{p}
+	for _, name := range p.fallback {
+		if fb, ok := e.byName[p.protocol+"/"+name]; ok {
+			candidates = append(candidates, fb)
+		}
+	}
+	if null, ok := e.byName[p.protocol+"/NULL"]; ok && p.protocol == "TCP" && p != null {
+		candidates = append(candidates, null)
+	}
+
+	subject := latin1(resp)
+	var soft *serviceMatch
+	var softGroups []string
+	for _, c := range candidates {
+		for _, m := range c.matches {
+			groups := m.re.FindStringSubmatch(subject)
+			if groups == nil {
+				continue
+			}
+			if !m.soft {
+				return m, groups
+			}
+			if soft == nil {
+				soft, softGroups = m, groups
+			}
+		}
+	}
+	return soft, softGroups
+}
+
+// identify sends probes to an open TCP port until one of the replies is a
+// hard match, and records the service and version information on r.
+func (e *probeEngine) identify(r *Result) {
+	var soft *serviceMatch
+	var softGroups []string
+	for _, p := range e.probesFor("TCP", r.Port, versionIntensity) {
+		var resp []byte
+		if p.name == "NULL" && len(r.BannerRaw) > 0 {
+			resp = r.BannerRaw
+		} else {
+			resp = e.send(r, p)
+		}
+		if len(resp) == 0 {
+			continue
+		}
+		m, groups := e.match(p, resp)
+		if m == nil {
+			continue
+		}
+		if !m.soft {
+			m.apply(r, groups)
+			return
+		}
+		if soft == nil {
+			soft, softGroups = m, groups
+		}
+	}
+	if soft != nil {
+		soft.apply(r, softGroups)
+	}
+}
+
+// send connects to r's port, over TLS when the probe lists the port among
+// its sslports, sends the probe's payload and returns whatever comes back
+// before the probe's wait time, capped by -probe-wait, runs out.
+func (e *probeEngine) send(r *Result, p *serviceProbe) []byte {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	wait := probeWait
+	if p.totalWait > 0 && p.totalWait < wait {
+		wait = p.totalWait
+	}
+	if p.sslports[r.Port] && !p.ports[r.Port] {
+		tc := tls.Client(conn, &tls.Config{ServerName: r.Host, InsecureSkipVerify: true})
+		tc.SetDeadline(time.Now().Add(timeout))
+		if err := tc.Handshake(); err != nil {
+			return nil
+		}
+		conn = tc
+	}
+	if len(p.payload) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(wait))
+		if _, err := conn.Write(p.payload); err != nil {
+			return nil
+		}
+	}
+	return readBanner(conn, wait, 16*1024)
+}
+
+// apply records the match on r, expanding the version info templates.
+func (m *serviceMatch) apply(r *Result, groups []string) {
+	r.Service = m.service
+	r.Product = expandTemplate(m.product, groups)
+	r.Version = expandTemplate(m.version, groups)
+	r.ExtraInfo = expandTemplate(m.info, groups)
+	r.OS = expandTemplate(m.os, groups)
+	r.DeviceType = expandTemplate(m.device, groups)
+	r.CPE = nil
+	for _, c := range m.cpes {
+		r.CPE = append(r.CPE, expandTemplate(c, groups))
+	}
+}
+
+var templateFunc = regexp.MustCompile(`\$(P|SUBST|I)\((\d)(?:,"([^"]*)"(?:,"([^"]*)")?)?\)|\$(\d)`)
+
+// expandTemplate substitutes submatches into a version info template.
+func expandTemplate(tmpl string, groups []string) string {
+	return templateFunc.ReplaceAllStringFunc(tmpl, func(s string) string {
+		sm := templateFunc.FindStringSubmatch(s)
+		idx := sm[2]
+		if idx == "" {
+			idx = sm[5]
+		}
+		n, _ := strconv.Atoi(idx)
+		if n >= len(groups) {
+			return ""
+		}
+		group := fromLatin1(groups[n])
+
+		switch sm[1] {
+		case "P":
+			var b []byte
+			for _, c := range group {
+				if c >= 0x20 && c <= 0x7e {
+					b = append(b, c)
+				}
+			}
+			return string(b)
+		case "SUBST":
+			return strings.Replace(string(group), sm[3], sm[4], -1)
+		case "I":
+			if len(group) == 0 || len(group) > 8 {
+				return ""
+			}
+			buf := make([]byte, 8)
+			if sm[3] == "<" {
+				copy(buf, group)
+				return strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10)
+			}
+			copy(buf[8-len(group):], group)
+			return strconv.FormatUint(binary.BigEndian.Uint64(buf), 10)
+		default:
+			return string(group)
+		}
+	})
+}
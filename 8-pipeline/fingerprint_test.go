@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServiceProbes(t *testing.T) {
+	const probes = `# Excerpt in the layout of nmap-service-probes.
+Exclude T:9100-9107,U:30000-40000
+
+Probe TCP NULL q||
+totalwaitms 6000
+tcpwrappedms 3000
+match ssh m|^SSH-([\d.]+)-OpenSSH_([\w._-]+)\r?\n| p/OpenSSH/ v/$2/ i/protocol $1/ cpe:/a:openbsd:openssh:$2/
+softmatch ftp m/^220[- ].*\r\n/
+match ftp m|^220(?=[- ])| p/lookahead/
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80-85,8080
+sslports 443
+fallback NULL
+match http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: nginx/([\d.]+)\r\n|s p/nginx/ v/$1/ cpe:/a:igor_sysoev:nginx:$1/
+`
+	e, err := parseServiceProbes(strings.NewReader(probes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.probes) != 2 {
+		t.Fatalf("parsed %d probes, want 2", len(e.probes))
+	}
+	if want := (portSet{9100: true, 9101: true, 9102: true, 9103: true, 9104: true, 9105: true, 9106: true, 9107: true}); !reflect.DeepEqual(e.exclude, want) {
+		t.Errorf("exclude = %v, want %v", e.exclude, want)
+	}
+	if e.skipped != 1 {
+		t.Errorf("skipped %d matches, want the lookahead one", e.skipped)
+	}
+
+	null := e.byName["TCP/NULL"]
+	if null == nil {
+		t.Fatal("no TCP/NULL probe")
+	}
+	if len(null.payload) != 0 || null.totalWait != 6*time.Second || len(null.matches) != 2 || !null.matches[1].soft {
+		t.Errorf("NULL probe = %+v", null)
+	}
+
+	get := e.byName["TCP/GetRequest"]
+	if get == nil {
+		t.Fatal("no TCP/GetRequest probe")
+	}
+	if string(get.payload) != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("GetRequest payload = %q", get.payload)
+	}
+	if !get.ports[80] || !get.ports[85] || !get.ports[8080] || get.ports[86] || !get.sslports[443] {
+		t.Errorf("GetRequest ports = %v, sslports = %v", get.ports, get.sslports)
+	}
+	if !reflect.DeepEqual(get.fallback, []string{"NULL"}) {
+		t.Errorf("GetRequest fallback = %v", get.fallback)
+	}
+
+	tests := []struct {
+		name    string
+		probe   *serviceProbe
+		resp    string
+		service string
+		version string
+		soft    bool
+	}{
+		{name: "own match", probe: get, resp: "HTTP/1.1 200 OK\r\nDate: Mon, 19 Oct 2026 10:00:00 GMT\r\nServer: nginx/1.24.0\r\n\r\n", service: "http", version: "1.24.0"},
+		{name: "fallback", probe: get, resp: "SSH-2.0-OpenSSH_9.6\r\n", service: "ssh", version: "9.6"},
+		{name: "softmatch", probe: null, resp: "220 Service ready\r\n", service: "ftp", soft: true},
+		{name: "no match", probe: null, resp: "+OK POP3 ready\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, groups := e.match(tt.probe, []byte(tt.resp))
+			if m == nil {
+				if tt.service != "" {
+					t.Errorf("no match, want %s", tt.service)
+				}
+				return
+			}
+			var r Result
+			m.apply(&r, groups)
+			if r.Service != tt.service || r.Version != tt.version || m.soft != tt.soft {
+				t.Errorf("matched %s %q (soft %t), want %s %q (soft %t)", r.Service, r.Version, m.soft, tt.service, tt.version, tt.soft)
+			}
+		})
+	}
+}
+
+func TestParseServiceProbesErrors(t *testing.T) {
+	tests := []struct {
+		probes string
+		want   string
+	}{
+		{"match ssh m|^SSH-|", "line 1: match directive before any Probe"},
+		{"Probe TCP NULL", "line 1: malformed Probe directive"},
+		{"Probe SCTP NULL q||", "line 1: malformed Probe directive"},
+		{"Probe TCP NULL |GET|", "line 1: malformed probe payload"},
+		{"Probe TCP GetRequest q|GET / HTTP/1.0", "line 1: unterminated probe payload"},
+		{"Probe TCP NULL q||\nports 80-http", "line 2: failed to convert http"},
+		{"Probe TCP NULL q||\nrarity high", "line 2:"},
+		{"Probe TCP NULL q||\ntotalwaitms 5s", "line 2:"},
+		{"Probe TCP NULL q||\n\nmatch ssh m|^SSH-", "line 3: unterminated match pattern"},
+	}
+	for _, tt := range tests {
+		_, err := parseServiceProbes(strings.NewReader(tt.probes))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("parseServiceProbes(%q) error = %v, want %q", tt.probes, err, tt.want)
+		}
+	}
+}
+
+func TestParseMatchDirective(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string // after "match " or "softmatch "
+		soft    bool
+		resp    string
+		want    Result
+		wantErr error // nil for a parse error other than errUnsupportedRegexp
+		bad     bool
+	}{
+		{
+			name: "openssh ubuntu",
+			line: `ssh m|^SSH-([\d.]+)-OpenSSH_([\w._-]+)[ -]{1,2}Ubuntu[ -_]([^\r\n]+)\r?\n| p/OpenSSH/ v/$2 Ubuntu $3/ i/protocol $1/ o/Linux/ cpe:/a:openbsd:openssh:$2/ cpe:/o:canonical:ubuntu_linux/ cpe:/o:linux:linux_kernel/a`,
+			resp: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6\r\n",
+			want: Result{
+				Service:   "ssh",
+				Product:   "OpenSSH",
+				Version:   "8.9p1 Ubuntu 3ubuntu0.6",
+				ExtraInfo: "protocol 2.0",
+				OS:        "Linux",
+				CPE:       []string{"cpe:/a:openbsd:openssh:8.9p1", "cpe:/o:canonical:ubuntu_linux", "cpe:/o:linux:linux_kernel"},
+			},
+		},
+		{
+			name: "case-insensitive flag",
+			line: `smtp m|^220[- ].*ESMTP Postfix|i p/Postfix smtpd/ cpe:/a:postfix:postfix/a`,
+			resp: "220 mail.example.com esmtp postfix\r\n",
+			want: Result{Service: "smtp", Product: "Postfix smtpd", CPE: []string{"cpe:/a:postfix:postfix"}},
+		},
+		{
+			name: "dot-all flag",
+			line: `http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: Apache/([\d.]+)|s p/Apache httpd/ v/$1/ cpe:/a:apache:http_server:$1/`,
+			resp: "HTTP/1.1 403 Forbidden\r\nDate: Mon, 19 Oct 2026 10:00:00 GMT\r\nServer: Apache/2.4.58\r\n\r\n",
+			want: Result{Service: "http", Product: "Apache httpd", Version: "2.4.58", CPE: []string{"cpe:/a:apache:http_server:2.4.58"}},
+		},
+		{
+			name: "other delimiters",
+			line: `mysql m=^.\0\0\0\x0a(5\.[-_~.+\w]+)\0=s p=MySQL= v|$1| d/database/`,
+			resp: "J\x00\x00\x00\x0a5.7.44\x00",
+			want: Result{Service: "mysql", Product: "MySQL", Version: "5.7.44", DeviceType: "database"},
+		},
+		{
+			name: "softmatch",
+			line: `ftp m/^220[- ].*\r\n/`,
+			soft: true,
+			resp: "220 Welcome\r\n",
+			want: Result{Service: "ftp"},
+		},
+		{
+			name: "out-of-range reference",
+			line: `ssh m|^SSH-([\d.]+)-| p/OpenSSH/ v/$9/ i/protocol $1/`,
+			resp: "SSH-2.0-OpenSSH_9.6\r\n",
+			want: Result{Service: "ssh", Product: "OpenSSH", ExtraInfo: "protocol 2.0"},
+		},
+		{name: "no pattern", line: "ssh", bad: true},
+		{name: "not m", line: `ssh q|^SSH-|`, bad: true},
+		{name: "unterminated pattern", line: `ssh m|^SSH-`, bad: true},
+		{name: "unterminated version info", line: `ssh m|^SSH-| p/OpenSSH`, bad: true},
+		{name: "missing version info delimiter", line: `ssh m|^SSH-| p`, bad: true},
+		{name: "lookbehind", line: `http m|(?<=Server: )nginx| p/nginx/`, bad: true, wantErr: errUnsupportedRegexp},
+		{name: "backreference", line: `ftp m|^(\d\d\d)-.*\r\n\1 | p/multiline/`, bad: true, wantErr: errUnsupportedRegexp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseMatchDirective(tt.line, tt.soft)
+			if tt.bad {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", m)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr == nil && errors.Is(err, errUnsupportedRegexp) {
+					t.Errorf("error = %v, want a malformed directive error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.soft != tt.soft {
+				t.Errorf("soft = %t, want %t", m.soft, tt.soft)
+			}
+			groups := m.re.FindStringSubmatch(latin1([]byte(tt.resp)))
+			if groups == nil {
+				t.Fatalf("%s does not match %q", m.re, tt.resp)
+			}
+			var r Result
+			m.apply(&r, groups)
+			if !reflect.DeepEqual(r, tt.want) {
+				t.Errorf("applied %+v, want %+v", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	groups := []string{"whole", "8.9p1", "1_2_3", "a\x01b\x7fc", latin1([]byte{0xff, 0x00})}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"OpenSSH", "OpenSSH"},
+		{"$1", "8.9p1"},
+		{"v$1 ($0)", "v8.9p1 (whole)"},
+		{"$9", ""},
+		{"$1-$5", "8.9p1-"},
+		{"$P(3)", "abc"},
+		{`$SUBST(2,"_",".")`, "1.2.3"},
+		{`$I(4,">")`, "65280"},
+		{`$I(4,"<")`, "255"},
+		{`$I(7,">")`, ""},
+		{"$P(8)", ""},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.tmpl, groups); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+var detectProtocols bool
+var grpcService string
+var webSocketPath string
+
+func init() {
+	flag.BoolVar(&detectProtocols, "detect-protocols", false, "Detect HTTP/2, gRPC and WebSocket endpoints and check gRPC health (implies -tls and -http).")
+	flag.StringVar(&grpcService, "grpc-service", "", "Service to ask grpc.health.v1.Health/Check about (empty for the whole server).")
+	flag.StringVar(&webSocketPath, "websocket-path", "/", "Path to request a WebSocket upgrade on.")
+}
+
+// appProtocols lists the application protocols detected on a port beyond
+// what the HTTP probe found.
+type appProtocols struct {
+	// Detected holds "h2" (HTTP/2 negotiated with ALPN), "h2c" (HTTP/2
+	// over cleartext), "grpc" and "websocket".
+	Detected    []string `json:"detected"`
+	GRPCStatus  string   `json:"grpcStatus,omitempty"`
+	GRPCMessage string   `json:"grpcMessage,omitempty"`
+	// Health is the status returned by grpc.health.v1.Health/Check, e.g.
+	// SERVING. It is empty when the server does not implement the check,
+	// which GRPCStatus then shows as UNIMPLEMENTED.
+	Health string `json:"health,omitempty"`
+}
+
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED",
+	"NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+var healthStatusNames = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// detectAppProtocols looks for HTTP/2 and gRPC on every open port and for
+// WebSocket support on ports that answered the HTTP probe. TLS ports only
+// speak HTTP/2 if they negotiated h2 with ALPN; other ports are sent the
+// HTTP/2 connection preface directly, as gRPC clients do.
+func detectAppProtocols(r *Result) {
+	info := &appProtocols{}
+	switch {
+	case r.TLS != nil && r.TLS.ALPN == "h2":
+		info.Detected = append(info.Detected, "h2")
+		probeGRPC(r, info, true)
+	case r.TLS == nil:
+		probeGRPC(r, info, false)
+	}
+	if r.HTTP != nil && probeWebSocket(r) {
+		info.Detected = append(info.Detected, "websocket")
+	}
+	if len(info.Detected) > 0 {
+		r.AppProtocols = info
+	}
+}
+
+// probeGRPC opens an HTTP/2 connection and calls grpc.health.v1.Health/Check
+// on it, recording h2c if the server answered the preface over cleartext and
+// grpc if it answered the call like a gRPC server.
+func probeGRPC(r *Result, info *appProtocols, useTLS bool) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+		tc := tls.Client(conn, &tls.Config{ServerName: r.Host, NextProtos: []string{"h2"}, InsecureSkipVerify: true})
+		if err := tc.Handshake(); err != nil || tc.ConnectionState().NegotiatedProtocol != "h2" {
+			return
+		}
+		conn = tc
+	}
+
+	var headers bytes.Buffer
+	enc := hpack.NewEncoder(&headers)
+	for _, h := range [][2]string{
+		{":method", "POST"},
+		{":scheme", scheme},
+		{":path", "/grpc.health.v1.Health/Check"},
+		{":authority", urlHost(r.address())},
+		{"content-type", "application/grpc"},
+		{"te", "trailers"},
+		{"user-agent", "portscan"},
+	} {
+		enc.WriteField(hpack.HeaderField{Name: h[0], Value: h[1]})
+	}
+	// HealthCheckRequest has the service name as field 1.
+	var req []byte
+	if grpcService != "" {
+		req = binary.AppendUvarint([]byte{0x0a}, uint64(len(grpcService)))
+		req = append(req, grpcService...)
+	}
+	msg := make([]byte, 5, 5+len(req))
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(req)))
+	msg = append(msg, req...)
+
+	w := bufio.NewWriter(conn)
+	fr := http2.NewFramer(w, conn)
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	w.WriteString(http2.ClientPreface)
+	fr.WriteSettings()
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: headers.Bytes(), EndHeaders: true})
+	fr.WriteData(1, true, msg)
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	var data []byte
+	var isGRPC bool
+frames:
+	for first := true; ; first = false {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			break
+		}
+		// Anything but SETTINGS first means the port does not speak HTTP/2;
+		// an HTTP/1.1 server answers the preface with a 400 instead, which
+		// the framer rejects as too large a frame.
+		if first {
+			if _, ok := f.(*http2.SettingsFrame); !ok {
+				return
+			}
+			if !useTLS {
+				info.Detected = append(info.Detected, "h2c")
+			}
+		}
+
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				fr.WriteSettingsAck()
+				w.Flush()
+			}
+		case *http2.GoAwayFrame:
+			break frames
+		case *http2.RSTStreamFrame:
+			if f.StreamID == 1 {
+				break frames
+			}
+		case *http2.MetaHeadersFrame:
+			if f.StreamID != 1 {
+				continue
+			}
+			for _, hf := range f.Fields {
+				switch hf.Name {
+				case "content-type":
+					isGRPC = isGRPC || strings.HasPrefix(hf.Value, "application/grpc")
+				case "grpc-status":
+					isGRPC = true
+					info.GRPCStatus = hf.Value
+					if code, err := strconv.Atoi(hf.Value); err == nil && code >= 0 && code < len(grpcStatusNames) {
+						info.GRPCStatus = grpcStatusNames[code]
+					}
+				case "grpc-message":
+					info.GRPCMessage = hf.Value
+				}
+			}
+			if f.StreamEnded() {
+				break frames
+			}
+		case *http2.DataFrame:
+			if f.StreamID != 1 {
+				continue
+			}
+			data = append(data, f.Data()...)
+			if f.StreamEnded() {
+				break frames
+			}
+		}
+	}
+
+	// A gRPC message is a 5-byte prefix and a HealthCheckResponse, whose
+	// status is field 1 and absent when UNKNOWN.
+	if len(data) >= 5 {
+		var status uint64
+		if body := data[5:]; len(body) >= 2 && body[0] == 0x08 {
+			if v, n := binary.Uvarint(body[1:]); n > 0 {
+				status = v
+			}
+		}
+		if status < uint64(len(healthStatusNames)) {
+			info.Health = healthStatusNames[status]
+		}
+	}
+
+	if isGRPC {
+		info.Detected = append(info.Detected, "grpc")
+	}
+}
+
+// probeWebSocket asks an HTTP port to upgrade to a WebSocket and checks the
+// handshake answer (RFC 6455, section 4.2.2).
+func probeWebSocket(r *Result) bool {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return false
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	client := httpClient()
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequest(http.MethodGet, r.HTTP.Scheme+"://"+urlHost(r.address())+webSocketPath, nil)
+	if err != nil {
+		return false
+	}
+	req.Host = hostHeader(r.Host)
+	req.Header.Set("User-Agent", "portscan")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		resp.Header.Get("Sec-WebSocket-Accept") == base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// appProtocolColumns returns the CSV columns describing detected protocols.
+func (r Result) appProtocolColumns() []string {
+	ap := r.AppProtocols
+	if ap == nil {
+		return make([]string, 3)
+	}
+	return []string{strings.Join(ap.Detected, " "), ap.GRPCStatus, ap.Health}
+}
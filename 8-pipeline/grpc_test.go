@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// healthServer implements grpc.health.v1.Health/Check, answering SERVING
+// for the services it knows and NOT_FOUND for the rest.
+func healthServer(services map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || r.Header.Get("Content-Type") != "application/grpc" {
+			http.NotFound(w, r)
+			return
+		}
+		msg, _ := io.ReadAll(r.Body)
+		var service string
+		if len(msg) > 5 && msg[5] == 0x0a {
+			if n, size := binary.Uvarint(msg[6:]); size > 0 && 6+size+int(n) <= len(msg) {
+				service = string(msg[6+size : 6+size+int(n)])
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if !services[service] {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		// HealthCheckResponse{status: SERVING}
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, 1})
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func TestProbeGRPC(t *testing.T) {
+	// Long enough that its length takes two bytes as a varint.
+	long := "grpc.health.v1." + strings.Repeat("LongServiceName", 10)
+	services := map[string]bool{"": true, long: true}
+
+	tests := []struct {
+		name    string
+		tls     bool
+		service string
+		want    appProtocols
+	}{
+		{
+			name: "h2c",
+			want: appProtocols{Detected: []string{"h2c", "grpc"}, GRPCStatus: "OK", Health: "SERVING"},
+		},
+		{
+			name:    "h2c long service name",
+			service: long,
+			want:    appProtocols{Detected: []string{"h2c", "grpc"}, GRPCStatus: "OK", Health: "SERVING"},
+		},
+		{
+			name:    "h2c unknown service",
+			service: "missing",
+			want:    appProtocols{Detected: []string{"h2c", "grpc"}, GRPCStatus: "NOT_FOUND", GRPCMessage: "unknown service"},
+		},
+		{
+			name:    "tls long service name",
+			tls:     true,
+			service: long,
+			want:    appProtocols{Detected: []string{"grpc"}, GRPCStatus: "OK", Health: "SERVING"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *httptest.Server
+			if tt.tls {
+				srv = httptest.NewUnstartedServer(healthServer(services))
+				srv.EnableHTTP2 = true
+				srv.StartTLS()
+			} else {
+				srv = httptest.NewServer(h2c.NewHandler(healthServer(services), &http2.Server{}))
+			}
+			defer srv.Close()
+
+			grpcService = tt.service
+			defer func() { grpcService = "" }()
+			r := &Result{Host: "127.0.0.1", Port: srv.Listener.Addr().(*net.TCPAddr).Port, Protocol: protoTCP, Open: true, State: stateOpen}
+			var info appProtocols
+			probeGRPC(r, &info, tt.tls)
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("probeGRPC = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeGRPCHTTP1(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	r := &Result{Host: "127.0.0.1", Port: srv.Listener.Addr().(*net.TCPAddr).Port, Protocol: protoTCP, Open: true, State: stateOpen}
+	var info appProtocols
+	probeGRPC(r, &info, false)
+	if len(info.Detected) != 0 {
+		t.Errorf("detected %v on an HTTP/1.1 server", info.Detected)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpProbe bool
+var httpPath string
+
+func init() {
+	flag.BoolVar(&httpProbe, "http", false, "Send an HTTP request to each open port and record the response.")
+	flag.StringVar(&httpPath, "http-path", "/", "Path to request from HTTP ports.")
+}
+
+// httpInfo is what an HTTP request to a port revealed.
+type httpInfo struct {
+	Scheme        string        `json:"scheme"`
+	StatusCode    int           `json:"statusCode"`
+	Server        string        `json:"server,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	Location      string        `json:"location,omitempty"`
+	ContentLength int64         `json:"contentLength"`
+	ResponseTime  time.Duration `json:"responseTime"`
+}
+
+// maxHTTPBody bounds how much of a response body is read looking for a title.
+const maxHTTPBody = 1 << 20
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// probeHTTP works out whether an open port speaks HTTP or HTTPS and records
+// the response to a GET request. Ports already known to speak TLS are only
+// tried over HTTPS; others are tried over HTTPS first, since many HTTPS
+// servers answer plain HTTP with an error page rather than failing.
+func probeHTTP(r *Result) {
+	schemes := []string{"https", "http"}
+	if r.TLS != nil {
+		schemes = []string{"https"}
+	}
+	for _, scheme := range schemes {
+		if info, err := fetchHTTP(r, scheme, httpPath); err == nil {
+			r.HTTP = info
+			return
+		}
+	}
+}
+
+// fetchHTTP requests path from the port over scheme without following
+// redirects.
+func fetchHTTP(r *Result, scheme, path string) (*httpInfo, error) {
+	resp, body, elapsed, err := httpGet(r, scheme, path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &httpInfo{
+		Scheme:        scheme,
+		StatusCode:    resp.StatusCode,
+		Server:        resp.Header.Get("Server"),
+		Title:         htmlTitle(body),
+		Location:      resp.Header.Get("Location"),
+		ContentLength: resp.ContentLength,
+		ResponseTime:  elapsed,
+	}
+	if info.ContentLength < 0 {
+		info.ContentLength = int64(len(body))
+	}
+	return info, nil
+}
+
+// httpGet requests path from the port over scheme and returns the response
+// along with up to maxHTTPBody bytes of its body, which has been closed.
+func httpGet(r *Result, scheme, path string) (*http.Response, []byte, time.Duration, error) {
+	client := httpClient()
+	defer client.CloseIdleConnections()
+
+	url := scheme + "://" + urlHost(r.address()) + path
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	req.Host = hostHeader(r.Host)
+	req.Header.Set("User-Agent", "portscan")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return resp, body, time.Since(start), nil
+}
+
+// httpClient returns a client that neither verifies certificates nor follows
+// redirects, since it is used to describe servers rather than to trust them.
+func httpClient() *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// htmlTitle returns the text of the page's <title> element, if any.
+func htmlTitle(body []byte) string {
+	m := titleRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+}
+
+// httpColumns returns the CSV columns describing the HTTP response, or empty
+// columns when the port did not speak HTTP.
+func (r Result) httpColumns() []string {
+	h := r.HTTP
+	if h == nil {
+		return make([]string, 7)
+	}
+	return []string{
+		h.Scheme,
+		strconv.Itoa(h.StatusCode),
+		h.Server,
+		h.Title,
+		h.Location,
+		strconv.FormatInt(h.ContentLength, 10),
+		h.ResponseTime.String(),
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http"
+)
+
+var httpAudit bool
+
+func init() {
+	flag.BoolVar(&httpAudit, "http-audit", false, "Audit HTTP ports for missing security headers and exposed endpoints (implies -http).")
+}
+
+// exposure is an endpoint that should not be reachable on a production
+// service, recognised by a marker in its response body.
+type exposure struct {
+	id       string
+	path     string
+	marker   string
+	severity string
+	title    string
+}
+
+var exposures = []exposure{
+	{"http-exposed-metrics", "/metrics", "# TYPE ", severityMedium, "Prometheus metrics exposed"},
+	{"http-exposed-pprof", "/debug/pprof/", "Types of profiles available", severityHigh, "Go pprof debug endpoint exposed"},
+	{"http-exposed-git", "/.git/HEAD", "ref: refs/", severityHigh, "Git repository exposed"},
+	{"http-exposed-server-status", "/server-status", "Server Status for", severityMedium, "Apache server-status page exposed"},
+}
+
+// defaultPages are markers of the welcome pages web servers ship with.
+var defaultPages = []string{
+	"Welcome to nginx!",
+	"Apache2 Ubuntu Default Page",
+	"Apache2 Debian Default Page",
+	"Test Page for the Apache HTTP Server",
+	"<h1>It works!</h1>",
+	"IIS Windows Server",
+	"Welcome to Caddy",
+}
+
+// directoryListings are markers of auto-generated directory indexes.
+var directoryListings = []string{
+	"<title>Index of /",
+	"<title>Directory listing for /",
+	"<h1>Index of /",
+}
+
+// auditHTTP checks a port that answered the HTTP probe for missing security
+// headers, default pages, directory listings and exposed debug endpoints.
+// Every request is a read-only GET.
+func auditHTTP(r *Result) {
+	if r.HTTP == nil {
+		return
+	}
+	scheme := r.HTTP.Scheme
+
+	if resp, body, _, err := httpGet(r, scheme, httpPath); err == nil {
+		auditHeaders(r, resp.Header)
+		if containsAny(body, directoryListings) {
+			r.addFinding("http-directory-listing", severityMedium, "Directory listing enabled", "GET "+httpPath)
+		}
+		if containsAny(body, defaultPages) {
+			r.addFinding("http-default-page", severityLow, "Default welcome page", "GET "+httpPath)
+		}
+	}
+
+	for _, e := range exposures {
+		resp, body, _, err := httpGet(r, scheme, e.path)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if bytes.Contains(body, []byte(e.marker)) {
+			r.addFinding(e.id, e.severity, e.title, "GET "+e.path)
+		}
+	}
+
+	// A TLS port that also answers plaintext HTTP lets clients, and
+	// anyone in between, talk to it unencrypted.
+	if scheme == "https" {
+		if resp, _, _, err := httpGet(r, "http", httpPath); err == nil && resp.StatusCode < http.StatusBadRequest {
+			r.addFinding("http-plaintext-on-tls", severityMedium, "Plaintext HTTP accepted on a TLS port", resp.Status)
+		}
+	}
+
+	sortFindings(r.Findings)
+}
+
+func auditHeaders(r *Result, h http.Header) {
+	if r.HTTP.Scheme == "https" && h.Get("Strict-Transport-Security") == "" {
+		r.addFinding("http-missing-hsts", severityLow, "Missing Strict-Transport-Security header", "")
+	}
+	if h.Get("Content-Security-Policy") == "" {
+		r.addFinding("http-missing-csp", severityLow, "Missing Content-Security-Policy header", "")
+	}
+	if h.Get("X-Frame-Options") == "" {
+		r.addFinding("http-missing-x-frame-options", severityLow, "Missing X-Frame-Options header", "")
+	}
+}
+
+func containsAny(body []byte, markers []string) bool {
+	for _, m := range markers {
+		if bytes.Contains(body, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,59 +1,253 @@
 package main
 
 import (
-	"encoding/csv"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
+var host string
 var ports string
 var outFile string
+var jsonFile string
+var junitFile string
+var webhookURL string
+var table bool
+var sinkBuffer int
+var workers int
+var timeout time.Duration
+var showStats bool
+var summaryFile string
 
 func init() {
-	flag.StringVar(&ports, "ports", "5400-5500", "Port(s) (e.g. 80, 22-100).")
-	flag.StringVar(&outFile, "outfile", "scans.csv", "Destination of scan results (defaults to scans.csv)")
+	flag.StringVar(&host, "host", "127.0.0.1", "Host(s) to scan (e.g. example.com, 10.0.0.1, 10.0.0.0/24,10.0.1.5).")
+	flag.StringVar(&ports, "ports", "5400-5500", "Port(s) (e.g. 80, 22-100, 22,80,U:53,161).")
+	flag.StringVar(&outFile, "outfile", "scans.csv", "Destination of CSV scan results (empty to disable).")
+	flag.StringVar(&jsonFile, "json", "", "Destination of JSON scan results (disabled by default).")
+	flag.StringVar(&junitFile, "junit", "", "Destination of a JUnit XML report of findings (disabled by default).")
+	flag.StringVar(&webhookURL, "webhook", "", "URL to POST each scan result to as JSON (disabled by default).")
+	flag.BoolVar(&table, "table", true, "Show scan progress and results on stdout.")
+	flag.IntVar(&sinkBuffer, "sink-buffer", 64, "Number of results each sink may fall behind before it slows the pipeline.")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "Number of concurrent scanners (defaults to # of logical CPUs).")
+	flag.DurationVar(&timeout, "timeout", 5*time.Second, "Timeout for each connection attempt.")
+	flag.BoolVar(&showStats, "stats", true, "Print scan statistics once the scan is over.")
+	flag.StringVar(&summaryFile, "summary", "", "Destination of the JSON scan summary (disabled by default).")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "assert":
+			os.Exit(runAssert(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
-	portsToScan, err := parsePortsToScan(ports)
+	if err := checkScanFlags(); err != nil {
+		fmt.Printf("Failed to parse flags: %s\n", err)
+		os.Exit(1)
+	}
+
+	portsToScan, err := parsePortSpec(ports)
 	if err != nil {
 		fmt.Printf("Failed to parse ports to scan: %s\n", err)
 		os.Exit(1)
 	}
 
-	dest, err := os.Create(outFile)
+	if ipv4Only && ipv6Only {
+		fmt.Println("Failed to parse hosts to scan: -4 and -6 cannot be used together")
+		os.Exit(1)
+	}
+	hostsToScan, err := parseTargets(host)
+	if err != nil {
+		fmt.Printf("Failed to parse hosts to scan: %s\n", err)
+		os.Exit(1)
+	}
+	sc, err := loadScope()
+	if err != nil {
+		fmt.Printf("Failed to load scan scope: %s\n", err)
+		os.Exit(1)
+	}
+	if dryRun {
+		plan, err := newScanPlan(hostsToScan, portsToScan, sc)
+		if err != nil {
+			fmt.Printf("Refusing to scan: %s\n", err)
+			os.Exit(1)
+		}
+		plan.print(os.Stdout)
+		return
+	}
+
+	names := newResolver(resolverAddr)
+	targets, excluded, err := sc.apply(expandTargets(hostsToScan, names, sc.needsAddresses()))
+	if err != nil {
+		fmt.Printf("Refusing to scan: %s\n", err)
+		os.Exit(1)
+	}
+	if excluded > 0 {
+		fmt.Fprintf(os.Stderr, "Excluded %d target(s)\n", excluded)
+	}
+	if !confirmScan(len(targets), len(targets)*portsToScan.count(), os.Stdin, os.Stderr) {
+		os.Exit(1)
+	}
+
+	var discovered []hostStatus
+	if discoverHosts {
+		pingPorts, err := parseDiscoveryPorts(discoveryPorts)
+		if err != nil {
+			fmt.Printf("Failed to parse discovery ports: %s\n", err)
+			os.Exit(1)
+		}
+		discovered = discover(targets, pingPorts, workers)
+		targets = liveTargets(discovered)
+		if table {
+			printDiscovery(os.Stdout, discovered)
+		}
+	}
+
+	if serviceProbesFile != "" {
+		serviceProbes, err = loadServiceProbes(serviceProbesFile)
+		if err != nil {
+			fmt.Printf("Failed to load service probes: %s\n", err)
+			os.Exit(1)
+		}
+		if serviceProbes.skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d service match rules using unsupported regular expression features\n", serviceProbes.skipped)
+		}
+	}
+
+	if scriptsDir != "" {
+		scripts, err = loadScripts(scriptsDir)
+		if err != nil {
+			fmt.Printf("Failed to load scripts: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if pluginsDir != "" {
+		plugins, err = loadPlugins(pluginsDir)
+		if err != nil {
+			fmt.Printf("Failed to load plugins: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var term *terminal
+	if table {
+		term = newTerminal(os.Stdout, len(targets)*portsToScan.count())
+	}
+
+	sinks, err := openSinks(term)
 	if err != nil {
 		fmt.Printf("Failed to create scan results destination: %s\n", err)
 		os.Exit(2)
 	}
 
+	stats := newScanStats(workers)
+
 	// pipeline
-	scanChan := store(dest, filter(scan(gen(portsToScan...))))
+	in := merge(gen(targets, protoTCP, portsToScan.tcp...), gen(targets, protoUDP, portsToScan.udp...))
+
+	scanned := stats.observe(scanAll(in, workers))
+	if term != nil {
+		scanned = term.progress(scanned)
+	}
+	if allAddresses {
+		scanned = compareAddresses(scanned, targets)
+	}
+	found := filter(scanned)
+	if resolveNames {
+		found = names.enrich(found, workers)
+	}
+	scanChan, errChan := tee(filterWhere(probe(found, workers), where), sinkBuffer, sinks...)
 
 	// unfiltered
-	// scanChan := store(dest, scan(gen(portsToScan...)))
+	// scanChan, errChan := tee(filterWhere(probe(scanned, workers), where), sinkBuffer, sinks...)
 
 	// broken up for explainability
-	// var scanChan <-chan scanOp
-	// scanChan = gen(portsToScan...)
-	// scanChan = scan(scanChan)
+	// var scanChan <-chan Result
+	// scanChan = gen(targets, protoTCP, portsToScan.tcp...)
+	// scanChan = merge(scan(scanChan), scan(scanChan))
+	// scanChan = stats.observe(scanChan)
+	// scanChan = term.progress(scanChan)
+	// scanChan = compareAddresses(scanChan, targets)
 	// scanChan = filter(scanChan)
-	// scanChan = store(dest, scanChan)
+	// scanChan = names.enrich(scanChan, workers)
+	// scanChan = probe(scanChan, workers)
+	// scanChan = filterWhere(scanChan, where)
+	// scanChan, errChan = tee(scanChan, sinkBuffer, sinks...)
+
+	for range scanChan {
+		// Every result has already been handed to the sinks; draining
+		// the channel is what keeps the pipeline moving.
+	}
+
+	var failed bool
+	for err := range errChan {
+		fmt.Printf("Failed to store scan results: %s\n", err)
+		failed = true
+	}
+
+	summary := stats.summary()
+	summary.Discovery = discovered
+	if showStats {
+		summary.print(os.Stdout)
+	}
+	if summaryFile != "" {
+		if err := writeSummary(summaryFile, summary); err != nil {
+			fmt.Printf("Failed to write scan summary: %s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(3)
+	}
+}
 
-	for s := range scanChan {
-		if !s.open && s.scanErr != fmt.Sprintf("dial tcp 127.0.0.1:%d: connect: connection refused", s.port) {
-			fmt.Println(s.scanErr)
+// openSinks builds the sinks selected on the command line. term, when not
+// nil, is included as the stdout sink.
+func openSinks(term *terminal) ([]Sink, error) {
+	var sinks []Sink
+	if term != nil {
+		sinks = append(sinks, term)
+	}
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newCSVSink(f))
+	}
+	if jsonFile != "" {
+		f, err := os.Create(jsonFile)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newJSONSink(f))
+	}
+	if junitFile != "" {
+		f, err := os.Create(junitFile)
+		if err != nil {
+			return nil, err
 		}
+		sinks = append(sinks, newJUnitSink(f))
 	}
+	if webhookURL != "" {
+		sinks = append(sinks, newWebhookSink(webhookURL, 10*time.Second))
+	}
+	return sinks, nil
 }
 
 func parsePortsToScan(portsFlag string) ([]int, error) {
@@ -88,51 +282,123 @@ func parsePortsToScan(portsFlag string) ([]int, error) {
 	return results, nil
 }
 
-type scanOp struct {
-	port         int
-	open         bool
-	scanErr      string
-	scanDuration time.Duration
+// Result is the outcome of scanning a single port on a host.
+type Result struct {
+	Host string `json:"host"`
+	// IP is the address the port was found on, which differs from Host
+	// when Host is a name.
+	IP string `json:"ip,omitempty"`
+	// Hostnames are the PTR names of IP, and Addresses every address Host
+	// resolves to, when -resolve is set.
+	Hostnames    []string        `json:"hostnames,omitempty"`
+	Addresses    []string        `json:"addresses,omitempty"`
+	Port         int             `json:"port"`
+	Protocol     string          `json:"protocol"`
+	Open         bool            `json:"open"`
+	State        string          `json:"state"`
+	Service      string          `json:"service,omitempty"`
+	Banner       string          `json:"banner,omitempty"`
+	BannerRaw    []byte          `json:"bannerRaw,omitempty"`
+	Product      string          `json:"product,omitempty"`
+	Version      string          `json:"version,omitempty"`
+	ExtraInfo    string          `json:"extraInfo,omitempty"`
+	OS           string          `json:"os,omitempty"`
+	DeviceType   string          `json:"deviceType,omitempty"`
+	CPE          []string        `json:"cpe,omitempty"`
+	TLS          *tlsInfo        `json:"tls,omitempty"`
+	HTTP         *httpInfo       `json:"http,omitempty"`
+	SSH          *sshInfo        `json:"ssh,omitempty"`
+	Capabilities *capabilityInfo `json:"capabilities,omitempty"`
+	AppProtocols *appProtocols   `json:"appProtocols,omitempty"`
+	DNS          *dnsInfo        `json:"dns,omitempty"`
+	Findings     []finding       `json:"findings,omitempty"`
+	ScanErr      string          `json:"scanError,omitempty"`
+	ErrClass     string          `json:"errorClass,omitempty"`
+	ScanDuration time.Duration   `json:"scanDuration"`
 }
 
-func (so scanOp) csvHeaders() []string {
-	return []string{"port", "open", "scanError", "scanDuration"}
+// Port states, following the vocabulary used by nmap.
+const (
+	stateOpen     = "open"
+	stateClosed   = "closed"
+	stateFiltered = "filtered"
+	stateError    = "error"
+)
+
+func (r Result) csvHeaders() []string {
+	return []string{"host", "ip", "hostnames", "addresses", "port", "protocol", "open", "state", "service", "banner", "bannerRaw", "product", "version", "extraInfo", "os", "deviceType", "cpe", "tlsVersion", "tlsCipherSuite", "tlsALPN", "certSubject", "certIssuer", "certSANs", "certNotAfter", "certFlags", "tlsAccepted", "tlsWeakCiphers", "httpScheme", "httpStatus", "httpServer", "httpTitle", "httpLocation", "httpContentLength", "httpResponseTime", "sshSoftware", "sshKexAlgorithms", "sshHostKeyAlgorithms", "sshCiphers", "sshMACs", "sshHostKeys", "capabilities", "startTLS", "authMechanisms", "appProtocols", "grpcStatus", "grpcHealth", "dnsVersion", "dnsResponseCode", "dnsFlags", "findings", "scanError", "errorClass", "scanDuration"}
 }
 
-func (so scanOp) asSlice() []string {
-	return []string{
-		strconv.FormatInt(int64(so.port), 10),
-		strconv.FormatBool(so.open),
-		so.scanErr,
-		so.scanDuration.String(),
+func (r Result) asSlice() []string {
+	values := []string{
+		r.Host,
+		r.IP,
+		strings.Join(r.Hostnames, " "),
+		strings.Join(r.Addresses, " "),
+		strconv.FormatInt(int64(r.Port), 10),
+		r.Protocol,
+		strconv.FormatBool(r.Open),
+		r.State,
+		r.Service,
+		r.Banner,
+		base64.StdEncoding.EncodeToString(r.BannerRaw),
+		r.Product,
+		r.Version,
+		r.ExtraInfo,
+		r.OS,
+		r.DeviceType,
+		strings.Join(r.CPE, " "),
 	}
+	values = append(values, r.tlsColumns()...)
+	values = append(values, r.httpColumns()...)
+	values = append(values, r.sshColumns()...)
+	values = append(values, r.capabilityColumns()...)
+	values = append(values, r.appProtocolColumns()...)
+	values = append(values, r.dnsColumns()...)
+	return append(values,
+		r.findingsColumn(),
+		r.ScanErr,
+		r.ErrClass,
+		r.ScanDuration.String(),
+	)
 }
 
-func gen(ports ...int) <-chan scanOp {
-	out := make(chan scanOp, len(ports))
+func gen(targets []target, protocol string, ports ...int) <-chan Result {
+	out := make(chan Result, len(ports))
 	go func() {
 		defer close(out)
-		for _, p := range ports {
-			out <- scanOp{port: p}
+		for _, t := range targets {
+			for _, p := range ports {
+				out <- Result{Host: t.host, IP: t.ip, Port: p, Protocol: protocol}
+			}
 		}
 	}()
 	return out
 }
 
-func scan(in <-chan scanOp) <-chan scanOp {
-	out := make(chan scanOp)
+func scan(in <-chan Result) <-chan Result {
+	out := make(chan Result)
 	go func() {
 		defer close(out)
 		for scan := range in {
-			address := fmt.Sprintf("127.0.0.1:%d", scan.port)
+			if scan.Protocol == protoUDP {
+				scanUDP(&scan)
+				out <- scan
+				continue
+			}
 			start := time.Now()
-			conn, err := net.Dial("tcp", address)
-			scan.scanDuration = time.Since(start)
+			conn, err := net.DialTimeout("tcp", scan.address(), timeout)
+			scan.ScanDuration = time.Since(start)
 			if err != nil {
-				scan.scanErr = err.Error()
+				scan.ScanErr = err.Error()
+				scan.ErrClass = errorClass(err)
+				scan.State = stateFromErrClass(scan.ErrClass)
 			} else {
+				scan.IP = remoteIP(conn)
 				conn.Close()
-				scan.open = true
+				scan.Open = true
+				scan.State = stateOpen
+				scan.Service = serviceName(protoTCP, scan.Port)
 			}
 			out <- scan
 		}
@@ -140,12 +406,49 @@ func scan(in <-chan scanOp) <-chan scanOp {
 	return out
 }
 
-func filter(in <-chan scanOp) <-chan scanOp {
-	out := make(chan scanOp)
+// checkScanFlags validates the flags that size the scan, so the scan and the
+// assert subcommand reject the same bad values.
+func checkScanFlags() error {
+	if workers < 1 {
+		return fmt.Errorf("-workers must be at least 1, got %d", workers)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("-timeout must be positive, got %s", timeout)
+	}
+	if sinkBuffer < 0 {
+		return fmt.Errorf("-sink-buffer must not be negative, got %d", sinkBuffer)
+	}
+	if bannerMaxBytes < 1 || bannerMaxBytes > maxBannerBytes {
+		return fmt.Errorf("-banner-bytes must be between 1 and %d, got %d", maxBannerBytes, bannerMaxBytes)
+	}
+	return nil
+}
+
+// remoteIP returns the address a connection was made to, which for a
+// hostname is whichever of its addresses the dialer settled on.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// scanAll fans in out to n scanners and merges their results back together.
+func scanAll(in <-chan Result, n int) <-chan Result {
+	var scanners []<-chan Result
+	for i := 0; i < n; i++ {
+		scanners = append(scanners, scan(in))
+	}
+	return merge(scanners...)
+}
+
+func filter(in <-chan Result) <-chan Result {
+	out := make(chan Result)
 	go func() {
 		defer close(out)
 		for scan := range in {
-			if scan.open {
+			if scan.Open || scan.State == stateOpenFiltered {
 				out <- scan
 			}
 		}
@@ -153,28 +456,61 @@ func filter(in <-chan scanOp) <-chan scanOp {
 	return out
 }
 
-func store(file io.Writer, in <-chan scanOp) <-chan scanOp {
-	csvWriter := csv.NewWriter(file)
-	out := make(chan scanOp)
-	go func() {
-		defer csvWriter.Flush()
-		defer close(out)
-		var headerWritten bool
-		for scan := range in {
-			if !headerWritten {
-				headers := scan.csvHeaders()
-				if err := csvWriter.Write(headers); err != nil {
-					fmt.Println(err)
-					break
-				}
-				headerWritten = true
-			}
-			values := scan.asSlice()
-			if err := csvWriter.Write(values); err != nil {
-				fmt.Println(err)
-				break
+func merge(chans ...<-chan Result) <-chan Result {
+	out := make(chan Result)
+	wg := sync.WaitGroup{}
+	wg.Add(len(chans))
+
+	for _, sc := range chans {
+		go func(sc <-chan Result) {
+			for scan := range sc {
+				out <- scan
 			}
-		}
+			wg.Done()
+		}(sc)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
 	}()
+
 	return out
 }
+
+// errorClass buckets a dial error into a small set of classes so that scan
+// statistics can be grouped by what went wrong rather than by message.
+func errorClass(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.Is(err, syscall.EMFILE):
+		return "too-many-open-files"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// stateFromErrClass maps an error class to the port state it implies.
+func stateFromErrClass(class string) string {
+	switch class {
+	case "refused", "reset":
+		return stateClosed
+	case "timeout", "unreachable":
+		return stateFiltered
+	default:
+		return stateError
+	}
+}
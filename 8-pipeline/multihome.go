@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+)
+
+var allAddresses bool
+
+func init() {
+	flag.BoolVar(&allAddresses, "all-addresses", false, "Scan every address a hostname resolves to, rather than whichever one connects first, and flag ports whose state differs between them.")
+}
+
+// compareAddresses is a pipeline stage that holds back the results for a
+// port on a hostname scanned at several addresses until every address has
+// been scanned, and flags the open ones when the port was not open on all of
+// them. Results for other hosts pass through untouched.
+func compareAddresses(in <-chan Result, targets []target) <-chan Result {
+	addrCount := make(map[string]int)
+	for _, t := range targets {
+		if t.ip != "" {
+			addrCount[t.host]++
+		}
+	}
+
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		pending := make(map[hostPort][]Result)
+		for r := range in {
+			if addrCount[r.Host] < 2 {
+				out <- r
+				continue
+			}
+			k := hostPort{host: r.Host, port: r.Port, protocol: r.Protocol}
+			pending[k] = append(pending[k], r)
+			if len(pending[k]) < addrCount[r.Host] {
+				continue
+			}
+			results := pending[k]
+			delete(pending, k)
+			flagStateMismatch(results)
+			for _, r := range results {
+				out <- r
+			}
+		}
+	}()
+	return out
+}
+
+// flagStateMismatch adds a finding to the open results for one port when
+// the port is open on some of a host's addresses but not others.
+func flagStateMismatch(results []Result) {
+	sort.Slice(results, func(i, j int) bool { return results[i].IP < results[j].IP })
+	var open, other []string
+	for _, r := range results {
+		if r.Open {
+			open = append(open, r.IP)
+		} else {
+			other = append(other, r.IP+" "+r.State)
+		}
+	}
+	if len(open) == 0 || len(other) == 0 {
+		return
+	}
+	detail := "open on " + strings.Join(open, ", ") + "; " + strings.Join(other, ", ")
+	for i := range results {
+		if results[i].Open {
+			results[i].addFinding("address-state-mismatch", severityLow, "Port state differs between the host's addresses", detail)
+		}
+	}
+}
+
+// multiHomedHosts returns the hosts that any one of the scans found ports on
+// at more than one address.
+func multiHomedHosts(scans ...[]Result) map[string]bool {
+	multiHomed := make(map[string]bool)
+	for _, results := range scans {
+		first := make(map[string]string)
+		for _, r := range results {
+			if r.IP == "" {
+				continue
+			}
+			if ip, ok := first[r.Host]; !ok {
+				first[r.Host] = r.IP
+			} else if ip != r.IP {
+				multiHomed[r.Host] = true
+			}
+		}
+	}
+	return multiHomed
+}
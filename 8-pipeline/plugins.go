@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"gopkg.in/yaml.v3"
+)
+
+var pluginsDir string
+var pluginTimeout time.Duration
+var pluginConcurrency int
+
+// plugins holds the plugins discovered in pluginsDir, if any.
+var plugins *pluginSet
+
+func init() {
+	flag.StringVar(&pluginsDir, "plugins", "", "Directory of external check plugins, each in a subdirectory with a plugin.yaml manifest.")
+	flag.DurationVar(&pluginTimeout, "plugin-timeout", 30*time.Second, "Time allowed for each plugin to check a port, unless its manifest sets one.")
+	flag.IntVar(&pluginConcurrency, "plugin-concurrency", 4, "Maximum number of plugin processes running at once.")
+}
+
+// pluginManifestName is the manifest file looked for in each subdirectory of
+// pluginsDir.
+const pluginManifestName = "plugin.yaml"
+
+// plugin is an external executable that checks open ports. Its manifest
+// declares the command to run, which is looked up in PATH or, when it is a
+// relative path such as ./check, in the plugin's directory, and the
+// ports and services it applies to:
+//
+//	name: smb-signing
+//	command: ["python3", "check.py"]
+//	services: ["microsoft-ds"]
+//	ports: [445]
+//	timeout: 10s
+//
+// For each matching open port, the command is started in the plugin's
+// directory with a pluginRequest as JSON on stdin, and must print a
+// pluginResponse as JSON on stdout before exiting with status 0. A plugin
+// without ports or services applies to every open port.
+type plugin struct {
+	Name     string        `yaml:"name"`
+	Command  []string      `yaml:"command"`
+	Services []string      `yaml:"services"`
+	Ports    []int         `yaml:"ports"`
+	Timeout  time.Duration `yaml:"timeout"`
+
+	dir string
+}
+
+// pluginRequest is what a plugin reads on stdin.
+type pluginRequest struct {
+	// Target is the address to connect to, as host:port.
+	Target   string `json:"target"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Result holds everything the earlier stages learned about the port.
+	Result Result `json:"result"`
+}
+
+// pluginResponse is what a plugin writes on stdout.
+type pluginResponse struct {
+	Findings []finding `json:"findings"`
+}
+
+func (p *plugin) appliesTo(r Result) bool {
+	if len(p.Ports) == 0 && len(p.Services) == 0 {
+		return true
+	}
+	for _, port := range p.Ports {
+		if port == r.Port {
+			return true
+		}
+	}
+	for _, service := range p.Services {
+		if service == r.Service {
+			return true
+		}
+	}
+	return false
+}
+
+type pluginSet struct {
+	plugins []*plugin
+	// sem caps the number of plugin processes across all probe workers.
+	sem *semaphore.Weighted
+}
+
+// loadPlugins reads the manifest of every plugin in dir, in name order.
+func loadPlugins(dir string) (*pluginSet, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*", pluginManifestName))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(manifests)
+
+	if pluginConcurrency < 1 {
+		return nil, errors.New("-plugin-concurrency must be at least 1")
+	}
+	ps := &pluginSet{sem: semaphore.NewWeighted(int64(pluginConcurrency))}
+	for _, manifest := range manifests {
+		p, err := loadPlugin(manifest)
+		if err != nil {
+			return nil, err
+		}
+		ps.plugins = append(ps.plugins, p)
+	}
+	if len(ps.plugins) == 0 {
+		return nil, fmt.Errorf("no plugins with a %s in %s", pluginManifestName, dir)
+	}
+	return ps, nil
+}
+
+func loadPlugin(manifest string) (*plugin, error) {
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	p := &plugin{dir: filepath.Dir(manifest)}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", manifest, err)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(p.dir)
+	}
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("%s: no command", manifest)
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = pluginTimeout
+	}
+	return p, nil
+}
+
+// run runs every plugin that applies to an open port. A plugin that fails is
+// reported on stderr and does not stop the others.
+func (ps *pluginSet) run(r *Result) {
+	for _, p := range ps.plugins {
+		if !p.appliesTo(*r) {
+			continue
+		}
+		if err := ps.invoke(p, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Plugin %s failed on %s: %s\n", p.Name, r.address(), err)
+		}
+	}
+	sortFindings(r.Findings)
+}
+
+// invoke runs p against r once a process slot is free, and records the
+// findings it reports. The plugin's timeout only starts once it is running.
+func (ps *pluginSet) invoke(p *plugin, r *Result) error {
+	req, err := json.Marshal(pluginRequest{
+		Target:   r.address(),
+		Host:     r.Host,
+		Port:     r.Port,
+		Protocol: r.Protocol,
+		Result:   *r,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ps.sem.Acquire(context.Background(), 1); err != nil {
+		return err
+	}
+	defer ps.sem.Release(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Dir = p.dir
+	cmd.Stdin = bytes.NewReader(req)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s", p.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", err, firstLine(msg))
+		}
+		return err
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return fmt.Errorf("reading output: %s", err)
+	}
+	for _, f := range resp.Findings {
+		if f.ID == "" || f.Title == "" {
+			return errors.New("finding without an id or title")
+		}
+		if _, ok := severityRank[f.Severity]; !ok {
+			return fmt.Errorf("finding %s: unknown severity %q", f.ID, f.Severity)
+		}
+	}
+	r.Findings = append(r.Findings, resp.Findings...)
+	return nil
+}
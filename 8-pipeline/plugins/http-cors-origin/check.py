@@ -0,0 +1,33 @@
+import json
+import ssl
+import sys
+import urllib.error
+import urllib.request
+
+ORIGIN = "https://portscan.invalid"
+
+req = json.load(sys.stdin)
+http = req["result"].get("http") or {}
+scheme = http.get("scheme", "https" if req["result"].get("tls") else "http")
+
+findings = []
+try:
+    ctx = ssl._create_unverified_context()
+    r = urllib.request.Request("%s://%s/" % (scheme, req["target"]), headers={"Origin": ORIGIN})
+    try:
+        resp = urllib.request.urlopen(r, timeout=5, context=ctx)
+    except urllib.error.HTTPError as err:
+        resp = err
+    allowed = resp.headers.get("Access-Control-Allow-Origin", "")
+    credentials = resp.headers.get("Access-Control-Allow-Credentials", "").lower() == "true"
+    if allowed == ORIGIN:
+        findings.append({
+            "id": "http-cors-origin-reflected",
+            "severity": "high" if credentials else "medium",
+            "title": "CORS allows any origin" + (" with credentials" if credentials else ""),
+            "detail": "Access-Control-Allow-Origin: " + allowed,
+        })
+except Exception:
+    pass
+
+json.dump({"findings": findings}, sys.stdout)
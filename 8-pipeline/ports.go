@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transport protocols a port can be scanned over.
+const (
+	protoTCP = "tcp"
+	protoUDP = "udp"
+)
+
+// portSpec is the set of ports to scan over each protocol.
+type portSpec struct {
+	tcp []int
+	udp []int
+}
+
+func (ps portSpec) count() int {
+	return len(ps.tcp) + len(ps.udp)
+}
+
+// parsePortSpec parses a comma-separated list of ports and ranges, as
+// accepted by parsePortsToScan, where a "T:" or "U:" prefix switches the
+// protocol for that item and those after it, e.g. "22,80,U:53,161,T:443".
+// Ports are scanned over TCP until a prefix says otherwise.
+func parsePortSpec(spec string) (portSpec, error) {
+	sets := map[string]map[int]bool{protoTCP: {}, protoUDP: {}}
+	proto := protoTCP
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case strings.HasPrefix(item, "T:"), strings.HasPrefix(item, "t:"):
+			proto, item = protoTCP, item[2:]
+		case strings.HasPrefix(item, "U:"), strings.HasPrefix(item, "u:"):
+			proto, item = protoUDP, item[2:]
+		}
+		if item == "" {
+			continue
+		}
+		ports, err := parsePortsToScan(item)
+		if err != nil {
+			return portSpec{}, err
+		}
+		for _, p := range ports {
+			sets[proto][p] = true
+		}
+	}
+
+	ps := portSpec{tcp: sortedPorts(sets[protoTCP]), udp: sortedPorts(sets[protoUDP])}
+	if ps.count() == 0 {
+		return portSpec{}, errors.New("unable to determine port(s) to scan")
+	}
+	return ps, nil
+}
+
+// String formats the spec in the form parsePortSpec accepts, collapsing
+// consecutive ports into ranges, e.g. "22,80-90,U:53".
+func (ps portSpec) String() string {
+	var items []string
+	if len(ps.tcp) > 0 {
+		items = append(items, compactPorts(ps.tcp))
+	}
+	if len(ps.udp) > 0 {
+		items = append(items, "U:"+compactPorts(ps.udp))
+	}
+	return strings.Join(items, ",")
+}
+
+// compactPorts formats sorted ports as a comma-separated list of ports and
+// ranges.
+func compactPorts(ports []int) string {
+	var items []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		item := strconv.Itoa(ports[i])
+		if j > i {
+			item += "-" + strconv.Itoa(ports[j])
+		}
+		items = append(items, item)
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
+
+func sortedPorts(set map[int]bool) []int {
+	ports := make([]int, 0, len(set))
+	for p := range set {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// probe runs the enabled application-level probes against open ports, each
+// as its own stage with n goroutines. The stages share n slots between them,
+// so that no more than n probes are in flight at once.
+func probe(in <-chan Result, n int) <-chan Result {
+	sem := semaphore.NewWeighted(int64(n))
+	if bannerGrab {
+		in = probeStage(in, n, limit(sem, grabBanner))
+	}
+	if tlsProbe || tlsEnum || detectProtocols {
+		in = probeStage(in, n, limit(sem, inspectTLS))
+	}
+	if tlsEnum {
+		in = probeStage(in, n, limit(sem, enumerateTLS))
+	}
+	if serviceProbes != nil {
+		in = probeStage(in, n, limit(sem, serviceProbes.identify))
+	}
+	if httpProbe || httpAudit || detectProtocols {
+		in = probeStage(in, n, limit(sem, probeHTTP))
+	}
+	if httpAudit {
+		in = probeStage(in, n, limit(sem, auditHTTP))
+	}
+	if detectProtocols {
+		in = probeStage(in, n, limit(sem, detectAppProtocols))
+	}
+	if sshProbe {
+		in = probeStage(in, n, limit(sem, probeSSH))
+	}
+	if capabilityProbe || ftpAnonymous {
+		in = probeStage(in, n, limit(sem, probeCapabilities))
+	}
+	if dnsProbe {
+		in = probeStageWhere(in, n, wantDNS, limit(sem, probeDNS))
+	}
+	if datastoreChecks {
+		in = probeStage(in, n, limit(sem, checkDatastore))
+	}
+	if scripts != nil {
+		in = probeStage(in, n, limit(sem, scripts.run))
+	}
+	if plugins != nil {
+		in = probeStage(in, n, limit(sem, plugins.run))
+	}
+	return in
+}
+
+// probeStage runs fn on every open TCP result passing through it using n
+// goroutines. Other results pass through untouched.
+func probeStage(in <-chan Result, n int, fn func(*Result)) <-chan Result {
+	return probeStageWhere(in, n, func(r Result) bool { return r.Open && r.Protocol == protoTCP }, fn)
+}
+
+// probeStageWhere is like probeStage for probes that pick their own results,
+// such as those that also speak UDP.
+func probeStageWhere(in <-chan Result, n int, want func(Result) bool, fn func(*Result)) <-chan Result {
+	var outs []<-chan Result
+	for i := 0; i < n; i++ {
+		out := make(chan Result)
+		go func(out chan<- Result) {
+			defer close(out)
+			for r := range in {
+				if want(r) {
+					fn(&r)
+				}
+				out <- r
+			}
+		}(out)
+		outs = append(outs, out)
+	}
+	return merge(outs...)
+}
+
+// limit wraps fn so that it holds one of sem's slots while it runs.
+func limit(sem *semaphore.Weighted, fn func(*Result)) func(*Result) {
+	return func(r *Result) {
+		sem.Acquire(context.Background(), 1)
+		defer sem.Release(1)
+		fn(r)
+	}
+}
+
+// address returns the dial address of the result's port. Once the port has
+// been found on an address, probes connect to that address rather than
+// resolving the host again.
+func (r Result) address() string {
+	host := r.Host
+	if r.IP != "" {
+		host = r.IP
+	}
+	return net.JoinHostPort(host, strconv.Itoa(r.Port))
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorDim    = "\x1b[2m"
+	colorGreen  = "\x1b[32m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	clearLine   = "\r\x1b[2K"
+)
+
+// terminal renders scan progress and results for a person watching the scan.
+// On a TTY it keeps a live progress bar at the bottom of the screen and
+// streams open ports above it as they are found; when output is piped it only
+// prints plain text.
+//
+// terminal is a Sink: Write streams open ports and Close prints the final
+// table. Progress is counted separately by the progress stage so that closed
+// ports, which are usually filtered out before reaching the sinks, still
+// count towards it.
+type terminal struct {
+	mu      sync.Mutex
+	w       io.Writer
+	tty     bool
+	color   bool
+	total   int
+	done    int
+	start   time.Time
+	results []Result
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newTerminal(f *os.File, total int) *terminal {
+	t := &terminal{
+		w:       f,
+		tty:     isTerminal(f),
+		total:   total,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	t.color = t.tty && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+
+	if !t.tty {
+		close(t.stopped)
+		return t
+	}
+
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.mu.Lock()
+				t.drawProgress()
+				t.mu.Unlock()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe
+// or a file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progress is a pipeline stage that counts every result passing through it.
+func (t *terminal) progress(in <-chan Result) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		for r := range in {
+			t.mu.Lock()
+			t.done++
+			t.mu.Unlock()
+			out <- r
+		}
+	}()
+	return out
+}
+
+func (t *terminal) Write(r Result) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.results = append(t.results, r)
+	if !r.Open {
+		return nil
+	}
+
+	if t.tty {
+		fmt.Fprint(t.w, clearLine)
+	}
+	_, err := fmt.Fprintf(t.w, "%s %s%s %s %s %s%s%s%s\n",
+		t.paint(colorGreen, r.State), net.JoinHostPort(r.Host, r.portString()), t.paint(colorDim, r.hostAlias()), r.describeService(),
+		t.paint(colorDim, latency(r.ScanDuration)), truncate(r.Banner, 60), t.tlsSummary(r), httpSummary(r), appProtocolSummary(r))
+	for _, f := range r.Findings {
+		fmt.Fprintf(t.w, "    %s %s %s\n", t.paintSeverity(f.Severity), f.Title, t.paint(colorDim, f.Detail))
+	}
+	if t.tty {
+		t.drawProgress()
+	}
+	return err
+}
+
+func (t *terminal) Close() error {
+	close(t.stop)
+	<-t.stopped
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tty {
+		fmt.Fprint(t.w, clearLine)
+	}
+	return t.printTable()
+}
+
+// drawProgress redraws the progress bar in place. t.mu must be held.
+func (t *terminal) drawProgress() {
+	const width = 30
+
+	elapsed := time.Since(t.start)
+	rate := float64(t.done) / elapsed.Seconds()
+
+	eta := "--"
+	if rate > 0 && t.done < t.total {
+		eta = (time.Duration(float64(t.total-t.done)/rate) * time.Second).Round(time.Second).String()
+	}
+
+	filled := width
+	if t.total > 0 {
+		filled = width * t.done / t.total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+	fmt.Fprintf(t.w, "%s[%s] %d/%d %.1f/s ETA %s", clearLine, bar, t.done, t.total, rate, eta)
+}
+
+// printTable prints the results grouped by host. t.mu must be held.
+func (t *terminal) printTable() error {
+	sort.Slice(t.results, func(i, j int) bool {
+		if t.results[i].Host != t.results[j].Host {
+			return t.results[i].Host < t.results[j].Host
+		}
+		if t.results[i].Port != t.results[j].Port {
+			return t.results[i].Port < t.results[j].Port
+		}
+		if t.results[i].Protocol != t.results[j].Protocol {
+			return t.results[i].Protocol < t.results[j].Protocol
+		}
+		return t.results[i].IP < t.results[j].IP
+	})
+
+	// Hostnames scanned at every address list the address of each port.
+	port, portHeader := Result.portString, "PORT"
+	if allAddresses {
+		port = func(r Result) string { return r.portString() + "\t" + r.IP }
+		portHeader = "PORT\tADDRESS"
+	}
+
+	fmt.Fprintln(t.w, "\nResults\n--------------")
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	var lastHost string
+	for i, r := range t.results {
+		if i == 0 || r.Host != lastHost {
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintln(tw, t.paint(colorBold, r.Host)+t.paint(colorDim, r.hostAliases()))
+			fmt.Fprintln(tw, portHeader+"\tSTATE\tSERVICE\tVERSION\tLATENCY\tTLS\tHTTP\tBANNER")
+			lastHost = r.Host
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", port(r), r.State, r.Service, r.versionString(), latency(r.ScanDuration), r.tlsString(), r.httpString(), truncate(r.Banner, 60))
+	}
+	if len(t.results) == 0 {
+		fmt.Fprintln(tw, "no open ports found")
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return t.printFindings()
+}
+
+// printFindings lists every finding, most severe first. t.mu must be held.
+func (t *terminal) printFindings() error {
+	type portFinding struct {
+		r Result
+		f finding
+	}
+	var all []portFinding
+	for _, r := range t.results {
+		for _, f := range r.Findings {
+			all = append(all, portFinding{r, f})
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return severityRank[all[i].f.Severity] > severityRank[all[j].f.Severity]
+	})
+
+	fmt.Fprintln(t.w, "\nFindings\n--------------")
+	for _, pf := range all {
+		if _, err := fmt.Fprintf(t.w, "%s %s%s %s %s\n", t.paintSeverity(pf.f.Severity), net.JoinHostPort(pf.r.Host, pf.r.portString()), t.paint(colorDim, pf.r.hostAlias()), pf.f.Title, t.paint(colorDim, pf.f.Detail)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paintSeverity colours a finding's severity by how serious it is.
+func (t *terminal) paintSeverity(severity string) string {
+	label := "[" + severity + "]"
+	switch {
+	case severityRank[severity] >= severityRank[severityHigh]:
+		return t.paint(colorRed, label)
+	case severityRank[severity] >= severityRank[severityMedium]:
+		return t.paint(colorYellow, label)
+	default:
+		return t.paint(colorDim, label)
+	}
+}
+
+// paint wraps s in the given colour when colour output is enabled.
+func (t *terminal) paint(color, s string) string {
+	if !t.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func latency(d time.Duration) string {
+	return d.Round(10 * time.Microsecond).String()
+}
+
+// portString formats the port nmap-style, e.g. "53/udp".
+func (r Result) portString() string {
+	return strconv.Itoa(r.Port) + "/" + r.Protocol
+}
+
+// versionString describes the product and version found on the port, if
+// service fingerprinting identified one.
+func (r Result) versionString() string {
+	s := strings.TrimSpace(r.Product + " " + r.Version)
+	if r.ExtraInfo != "" {
+		s += " (" + r.ExtraInfo + ")"
+	}
+	return strings.TrimSpace(s)
+}
+
+// describeService names the service on the port along with its version.
+func (r Result) describeService() string {
+	return strings.TrimSpace(r.Service + " " + r.versionString())
+}
+
+// tlsString summarises the TLS handshake as the negotiated version followed
+// by any problems with the certificate.
+func (r Result) tlsString() string {
+	if r.TLS == nil {
+		return ""
+	}
+	return strings.Join(append([]string{r.TLS.Version}, r.TLS.flags()...), " ")
+}
+
+// tlsSummary is tlsString for streamed lines, highlighting certificate
+// problems and showing when the certificate expires.
+func (t *terminal) tlsSummary(r Result) string {
+	if r.TLS == nil {
+		return ""
+	}
+	s := " [" + r.TLS.Version
+	if leaf := r.TLS.leaf(); leaf != nil {
+		s += " expires " + leaf.NotAfter.Format("2006-01-02")
+	}
+	s += "]"
+	if flags := r.TLS.flags(); len(flags) > 0 {
+		s += " " + t.paint(colorRed, strings.Join(flags, " "))
+	}
+	return s
+}
+
+// httpString summarises the HTTP response as the status code, server and
+// page title, or where the port redirects to.
+func (r Result) httpString() string {
+	h := r.HTTP
+	if h == nil {
+		return ""
+	}
+	s := strconv.Itoa(h.StatusCode)
+	if h.Server != "" {
+		s += " " + h.Server
+	}
+	if h.Location != "" {
+		return s + " -> " + h.Location
+	}
+	if h.Title != "" {
+		s += " " + strconv.Quote(truncate(h.Title, 40))
+	}
+	return s
+}
+
+func httpSummary(r Result) string {
+	if r.HTTP == nil {
+		return ""
+	}
+	return " [" + r.HTTP.Scheme + " " + r.httpString() + "]"
+}
+
+func appProtocolSummary(r Result) string {
+	ap := r.AppProtocols
+	if ap == nil {
+		return ""
+	}
+	s := " [" + strings.Join(ap.Detected, " ")
+	if ap.Health != "" {
+		s += " " + ap.Health
+	} else if ap.GRPCStatus != "" {
+		s += " " + ap.GRPCStatus
+	}
+	return s + "]"
+}
+
+// truncate shortens s to at most n bytes, marking the cut with an ellipsis.
+// The cut backs off to the start of a rune so that no rune is split.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
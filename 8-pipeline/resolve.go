@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var resolveNames bool
+var resolverAddr string
+
+func init() {
+	flag.BoolVar(&resolveNames, "resolve", false, "Look up the names of scanned addresses and the addresses behind scanned hostnames.")
+	flag.StringVar(&resolverAddr, "resolver", "", "DNS server to send -resolve lookups to, as host or host:port (defaults to the system resolver).")
+}
+
+// resolver looks up names and addresses for the enrichment stage. Lookups
+// are cached for the whole scan, and concurrent lookups of the same name
+// share a single query.
+type resolver struct {
+	r *net.Resolver
+
+	mu      sync.Mutex
+	lookups map[string]*lookup
+}
+
+// lookup is a cached PTR lookup for an address, or A/AAAA lookup for a
+// hostname. done is closed once names holds the answer.
+type lookup struct {
+	done  chan struct{}
+	names []string
+}
+
+// newResolver returns a resolver that queries server, or the system resolver
+// when server is empty.
+func newResolver(server string) *resolver {
+	res := &resolver{r: net.DefaultResolver, lookups: make(map[string]*lookup)}
+	if server == "" {
+		return res
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	res.r = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	return res
+}
+
+// enrich is a pipeline stage that records, using n goroutines, the PTR
+// names of results whose host is an address, and every address behind
+// results whose host is a name.
+func (res *resolver) enrich(in <-chan Result, n int) <-chan Result {
+	return probeStageWhere(in, n, func(Result) bool { return true }, func(r *Result) {
+		if ip, _ := parseIPZone(r.Host); ip != nil {
+			if r.IP == "" {
+				r.IP = r.Host
+			}
+			r.Hostnames = res.resolve(ip.String(), true)
+			return
+		}
+		r.Addresses = res.resolve(r.Host, false)
+	})
+}
+
+// resolve returns the PTR names of an address, when reverse is set, or the
+// addresses of a hostname. Failed lookups return nothing.
+func (res *resolver) resolve(host string, reverse bool) []string {
+	res.mu.Lock()
+	l, ok := res.lookups[host]
+	if !ok {
+		l = &lookup{done: make(chan struct{})}
+		res.lookups[host] = l
+	}
+	res.mu.Unlock()
+
+	if ok {
+		<-l.done
+		return l.names
+	}
+	defer close(l.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if reverse {
+		names, _ := res.r.LookupAddr(ctx, host)
+		for i := range names {
+			names[i] = strings.TrimSuffix(names[i], ".")
+		}
+		l.names = names
+		return l.names
+	}
+	addrs, _ := res.r.LookupIPAddr(ctx, host)
+	for _, a := range addrs {
+		l.names = append(l.names, a.String())
+	}
+	sort.Strings(l.names)
+	return l.names
+}
+
+// hostAlias returns the other name of the result's host, in brackets: the
+// address the port was found on for hostnames, or the first PTR name for
+// addresses.
+func (r Result) hostAlias() string {
+	switch {
+	case r.IP != "" && r.IP != r.Host:
+		return " (" + r.IP + ")"
+	case len(r.Hostnames) > 0:
+		return " (" + r.Hostnames[0] + ")"
+	}
+	return ""
+}
+
+// hostAliases returns every address behind the result's host, when it is a
+// name, or every PTR name of its address.
+func (r Result) hostAliases() string {
+	aliases := r.Addresses
+	if len(aliases) == 0 {
+		aliases = r.Hostnames
+	}
+	if len(aliases) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(aliases, ", ") + ")"
+}
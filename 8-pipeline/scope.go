@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+var exclude string
+var excludeFile string
+var allow string
+var allowFile string
+var confirmAbove int
+var assumeYes bool
+
+func init() {
+	addScopeFlags(flag.CommandLine)
+}
+
+// addScopeFlags registers the flags limiting what a scan may reach, which
+// the assert subcommand shares with the main scan.
+func addScopeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&exclude, "exclude", "", "Hosts, addresses and ranges never to scan (e.g. 10.0.0.1,10.0.5.0/24).")
+	fs.StringVar(&excludeFile, "exclude-file", "", "File listing hosts, addresses and ranges never to scan, one per line.")
+	fs.StringVar(&allow, "allow", "", "Networks the scan is allowed to reach; other targets make the scan refuse to start.")
+	fs.StringVar(&allowFile, "allow-file", "", "File listing networks the scan is allowed to reach, one per line.")
+	fs.IntVar(&confirmAbove, "confirm-above", 256, "Ask for confirmation before scanning more than this many hosts (0 to never ask).")
+	fs.BoolVar(&assumeYes, "yes", false, "Scan without asking for confirmation, however many hosts there are.")
+}
+
+// netList is a set of hostnames, addresses and networks, as given to
+// -exclude and -allow.
+type netList struct {
+	names map[string]bool
+	nets  []*net.IPNet
+}
+
+func (nl *netList) empty() bool {
+	return len(nl.names) == 0 && len(nl.nets) == 0
+}
+
+// add adds an entry, which may be a hostname, an address or a CIDR range.
+func (nl *netList) add(entry string) error {
+	if strings.HasPrefix(entry, "[") && strings.HasSuffix(entry, "]") {
+		entry = entry[1 : len(entry)-1]
+	}
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid range %s", entry)
+		}
+		nl.nets = append(nl.nets, ipnet)
+		return nil
+	}
+	if ip, _ := parseIPZone(entry); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		nl.nets = append(nl.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	nl.names[strings.ToLower(entry)] = true
+	return nil
+}
+
+// contains reports whether the target's host is listed by name or its
+// address falls in one of the networks.
+func (nl *netList) contains(t target) bool {
+	if nl.names[strings.ToLower(t.host)] {
+		return true
+	}
+	ip, _ := parseIPZone(t.dialHost())
+	if ip == nil {
+		return false
+	}
+	for _, n := range nl.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetList reads a comma-separated list of entries and, if file is not
+// empty, a file with one entry per line, where # starts a comment.
+func parseNetList(list, file string) (*netList, error) {
+	nl := &netList{names: make(map[string]bool)}
+	entries := strings.Split(list, ",")
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := s.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			entries = append(entries, line)
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		if err := nl.add(e); err != nil {
+			return nil, err
+		}
+	}
+	return nl, nil
+}
+
+// scope is what the scan may reach: nothing in excluded and, when allowed
+// is not empty, nothing outside it.
+type scope struct {
+	excluded *netList
+	allowed  *netList
+}
+
+func loadScope() (*scope, error) {
+	excluded, err := parseNetList(exclude, excludeFile)
+	if err != nil {
+		return nil, fmt.Errorf("exclusions: %s", err)
+	}
+	allowed, err := parseNetList(allow, allowFile)
+	if err != nil {
+		return nil, fmt.Errorf("allowed networks: %s", err)
+	}
+	return &scope{excluded: excluded, allowed: allowed}, nil
+}
+
+// needsAddresses reports whether hostnames must be resolved before the scan
+// so that their addresses can be checked against the scope.
+func (sc *scope) needsAddresses() bool {
+	return len(sc.excluded.nets) > 0 || !sc.allowed.empty()
+}
+
+// apply removes excluded targets and returns the rest, along with how many
+// were excluded. It fails if any remaining target is outside the allowed
+// networks, or is a hostname whose address is unknown.
+func (sc *scope) apply(targets []target) ([]target, int, error) {
+	var kept, outside []target
+	for _, t := range targets {
+		if sc.excluded.contains(t) {
+			continue
+		}
+		if !sc.allowed.empty() && !sc.allowed.contains(t) {
+			outside = append(outside, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if len(outside) > 0 {
+		names := make([]string, 0, 3)
+		for _, t := range outside {
+			if len(names) == cap(names) {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, t.dialHost())
+		}
+		return nil, 0, fmt.Errorf("%d target(s) outside the allowed networks: %s", len(outside), strings.Join(names, ", "))
+	}
+	return kept, len(targets) - len(kept), nil
+}
+
+// confirmScan asks on the terminal whether to go ahead with scanning more
+// than -confirm-above hosts, unless -yes was given. Without a terminal to
+// ask on, the scan does not go ahead.
+func confirmScan(hosts, probes int, in *os.File, out io.Writer) bool {
+	if assumeYes || confirmAbove <= 0 || hosts <= confirmAbove {
+		return true
+	}
+	if !isTerminal(in) {
+		fmt.Fprintf(out, "Refusing to scan %d hosts without confirmation; use -yes to go ahead\n", hosts)
+		return false
+	}
+	fmt.Fprintf(out, "About to scan %d hosts (%d probes). Continue? [y/N] ", hosts, probes)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(out)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNetListContains(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := os.WriteFile(file, []byte("# lab printers\n192.0.2.64/26\nprinter.example.com # by name\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nl, err := parseNetList("10.0.5.0/24, DB.example.com,[2001:db8::1],fe80::1%eth0", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t    target
+		want bool
+	}{
+		{target{host: "10.0.5.7"}, true},
+		{target{host: "10.0.6.7"}, false},
+		{target{host: "www.example.com", ip: "10.0.5.7"}, true},
+		{target{host: "www.example.com", ip: "10.0.6.7"}, false},
+		{target{host: "www.example.com"}, false},
+		{target{host: "db.example.com"}, true},
+		{target{host: "db.example.com", ip: "10.9.9.9"}, true},
+		{target{host: "2001:db8::1"}, true},
+		{target{host: "2001:db8::2"}, false},
+		{target{host: "fe80::1%lo"}, true},
+		{target{host: "192.0.2.100"}, true},
+		{target{host: "192.0.2.1"}, false},
+		{target{host: "PRINTER.example.com"}, true},
+	}
+	for _, tt := range tests {
+		if got := nl.contains(tt.t); got != tt.want {
+			t.Errorf("contains(%+v) = %t, want %t", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestParseNetListErrors(t *testing.T) {
+	for _, list := range []string{"10.0.0.0/33", "example.com/24"} {
+		if _, err := parseNetList(list, ""); err == nil || !strings.HasPrefix(err.Error(), "invalid range") {
+			t.Errorf("parseNetList(%q) error = %v, want an invalid range", list, err)
+		}
+	}
+	if _, err := parseNetList("", filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("parseNetList with a missing file succeeded")
+	}
+}
+
+func TestScopeApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		exclude  string
+		allow    string
+		targets  []target
+		want     []target
+		excluded int
+		wantErr  string
+	}{
+		{
+			name:    "no scope",
+			targets: []target{{host: "10.0.5.7"}, {host: "www.example.com"}},
+			want:    []target{{host: "10.0.5.7"}, {host: "www.example.com"}},
+		},
+		{
+			name:     "excluded range",
+			exclude:  "10.0.5.0/24",
+			targets:  []target{{host: "10.0.4.255"}, {host: "10.0.5.0"}, {host: "10.0.5.7"}, {host: "10.0.6.0"}},
+			want:     []target{{host: "10.0.4.255"}, {host: "10.0.6.0"}},
+			excluded: 2,
+		},
+		{
+			name:     "excluded hostname",
+			exclude:  "www.example.com",
+			targets:  []target{{host: "www.example.com", ip: "192.0.2.1"}, {host: "api.example.com", ip: "192.0.2.2"}},
+			want:     []target{{host: "api.example.com", ip: "192.0.2.2"}},
+			excluded: 1,
+		},
+		{
+			name:     "hostname resolving into an excluded range",
+			exclude:  "10.0.5.0/24",
+			targets:  []target{{host: "www.example.com", ip: "10.0.5.7"}, {host: "www.example.com", ip: "192.0.2.1"}},
+			want:     []target{{host: "www.example.com", ip: "192.0.2.1"}},
+			excluded: 1,
+		},
+		{
+			name:    "inside the allowed networks",
+			allow:   "192.0.2.0/24,2001:db8::/32",
+			targets: []target{{host: "192.0.2.1"}, {host: "www.example.com", ip: "2001:db8::80"}},
+			want:    []target{{host: "192.0.2.1"}, {host: "www.example.com", ip: "2001:db8::80"}},
+		},
+		{
+			name:    "outside the allowed networks",
+			allow:   "192.0.2.0/24",
+			targets: []target{{host: "192.0.2.1"}, {host: "198.51.100.1"}},
+			wantErr: "1 target(s) outside the allowed networks: 198.51.100.1",
+		},
+		{
+			name:    "hostname without an address",
+			allow:   "192.0.2.0/24",
+			targets: []target{{host: "www.example.com"}},
+			wantErr: "1 target(s) outside the allowed networks: www.example.com",
+		},
+		{
+			name:    "many outside the allowed networks",
+			allow:   "192.0.2.0/24",
+			targets: []target{{host: "10.0.0.1"}, {host: "10.0.0.2"}, {host: "10.0.0.3"}, {host: "10.0.0.4"}},
+			wantErr: "4 target(s) outside the allowed networks: 10.0.0.1, 10.0.0.2, 10.0.0.3, ...",
+		},
+		{
+			name:     "excluded from the allowed networks",
+			exclude:  "192.0.2.128/25",
+			allow:    "192.0.2.0/24",
+			targets:  []target{{host: "192.0.2.1"}, {host: "192.0.2.200"}},
+			want:     []target{{host: "192.0.2.1"}},
+			excluded: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			excluded, err := parseNetList(tt.exclude, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed, err := parseNetList(tt.allow, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc := &scope{excluded: excluded, allowed: allowed}
+
+			kept, n, err := sc.apply(tt.targets)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("apply error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(kept, tt.want) || n != tt.excluded {
+				t.Errorf("apply = %v, %d excluded, want %v, %d excluded", kept, n, tt.want, tt.excluded)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+var scriptsDir string
+var scriptTimeout time.Duration
+
+// scripts holds the scripts loaded from scriptsDir, if any.
+var scripts *scriptEngine
+
+func init() {
+	flag.StringVar(&scriptsDir, "scripts", "", "Directory of Starlark (*.star) check scripts to run against open ports.")
+	flag.DurationVar(&scriptTimeout, "script-timeout", 30*time.Second, "Time allowed for each script to check a port.")
+}
+
+// script is a Starlark check loaded from a file. It declares which ports it
+// applies to with ports and services lists, and a check function that is
+// called with a description of each matching open port:
+//
+//	services = ["http", "http-alt"]
+//
+//	def check(port):
+//	    conn = connect()
+//	    conn.send("TRACE / HTTP/1.0\r\n\r\n")
+//	    if match("^HTTP/1\\.[01] 200", conn.read()):
+//	        finding("http-trace", "low", "TRACE method enabled")
+//
+// A script without ports or services applies to every open port. Scripts
+// can only talk to the port they are checking, through the builtins in
+// scriptBuiltins.
+type script struct {
+	name     string
+	ports    map[int]bool
+	services map[string]bool
+	check    starlark.Callable
+}
+
+func (s *script) appliesTo(r Result) bool {
+	if len(s.ports) == 0 && len(s.services) == 0 {
+		return true
+	}
+	return s.ports[r.Port] || s.services[r.Service]
+}
+
+type scriptEngine struct {
+	scripts []*script
+}
+
+// scriptBuiltins is the whole API available to scripts.
+var scriptBuiltins = starlark.StringDict{
+	"connect": starlark.NewBuiltin("connect", scriptConnect),
+	"match":   starlark.NewBuiltin("match", scriptMatch),
+	"finding": starlark.NewBuiltin("finding", scriptFinding),
+}
+
+// loadScripts loads every *.star file in dir, in name order.
+func loadScripts(dir string) (*scriptEngine, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	e := &scriptEngine{}
+	for _, file := range files {
+		s, err := loadScript(file)
+		if err != nil {
+			return nil, err
+		}
+		e.scripts = append(e.scripts, s)
+	}
+	if len(e.scripts) == 0 {
+		return nil, fmt.Errorf("no *.star scripts in %s", dir)
+	}
+	return e, nil
+}
+
+func loadScript(file string) (*script, error) {
+	name := filepath.Base(file)
+	thread := &starlark.Thread{Name: name, Print: scriptPrint}
+	globals, err := starlark.ExecFile(thread, file, nil, scriptBuiltins)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &script{name: name, ports: make(map[int]bool), services: make(map[string]bool)}
+	check, ok := globals["check"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: no check function", name)
+	}
+	s.check = check
+
+	if v, ok := globals["ports"]; ok {
+		if err := eachInList(v, func(item starlark.Value) error {
+			port, err := starlark.AsInt32(item)
+			if err != nil {
+				return err
+			}
+			s.ports[port] = true
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("%s: ports: %s", name, err)
+		}
+	}
+	if v, ok := globals["services"]; ok {
+		if err := eachInList(v, func(item starlark.Value) error {
+			service, ok := starlark.AsString(item)
+			if !ok {
+				return fmt.Errorf("got %s, want string", item.Type())
+			}
+			s.services[service] = true
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("%s: services: %s", name, err)
+		}
+	}
+	return s, nil
+}
+
+func eachInList(v starlark.Value, fn func(starlark.Value) error) error {
+	iterable, ok := v.(starlark.Iterable)
+	if !ok {
+		return fmt.Errorf("got %s, want list", v.Type())
+	}
+	it := iterable.Iterate()
+	defer it.Done()
+	var item starlark.Value
+	for it.Next(&item) {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs every script that applies to an open port. A script that fails
+// is reported on stderr and does not stop the others.
+func (e *scriptEngine) run(r *Result) {
+	for _, s := range e.scripts {
+		if !s.appliesTo(*r) {
+			continue
+		}
+		if err := s.run(r); err != nil {
+			fmt.Fprintf(os.Stderr, "Script %s failed on %s: %s\n", s.name, r.address(), err)
+		}
+	}
+	sortFindings(r.Findings)
+}
+
+// scriptRun is the state of one script checking one port, kept in its
+// thread so that builtins can reach it.
+type scriptRun struct {
+	result   *Result
+	conns    []net.Conn
+	deadline time.Time // when -script-timeout cancels the thread
+}
+
+func (s *script) run(r *Result) error {
+	sr := &scriptRun{result: r, deadline: time.Now().Add(scriptTimeout)}
+	defer func() {
+		for _, c := range sr.conns {
+			c.Close()
+		}
+	}()
+
+	thread := &starlark.Thread{Name: s.name, Print: scriptPrint}
+	thread.SetLocal("run", sr)
+	timer := time.AfterFunc(scriptTimeout, func() { thread.Cancel("timed out") })
+	defer timer.Stop()
+
+	port := starlarkstruct.FromStringDict(starlark.String("port"), starlark.StringDict{
+		"host":     starlark.String(r.Host),
+		"port":     starlark.MakeInt(r.Port),
+		"protocol": starlark.String(r.Protocol),
+		"service":  starlark.String(r.Service),
+		"banner":   starlark.String(r.Banner),
+		"product":  starlark.String(r.Product),
+		"version":  starlark.String(r.Version),
+		"tls":      starlark.Bool(r.TLS != nil),
+	})
+	_, err := starlark.Call(thread, s.check, starlark.Tuple{port}, nil)
+	return err
+}
+
+func scriptPrint(thread *starlark.Thread, msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", thread.Name, msg)
+}
+
+// scriptConnect implements connect(tls=False), which opens a connection to
+// the port being checked.
+func scriptConnect(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var useTLS bool
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "tls?", &useTLS); err != nil {
+		return nil, err
+	}
+	sr, ok := thread.Local("run").(*scriptRun)
+	if !ok {
+		return nil, errors.New("connect can only be called from check")
+	}
+
+	conn, err := net.DialTimeout("tcp", sr.result.address(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	sr.conns = append(sr.conns, conn)
+	c := &scriptConn{conn: conn, host: sr.result.Host, deadline: sr.deadline}
+	if useTLS {
+		if err := c.upgrade(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// scriptMatch implements match(pattern, s), which returns the match and its
+// groups as a tuple, or None.
+func scriptMatch(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return starlark.None, nil
+	}
+	groups := make(starlark.Tuple, len(m))
+	for i, g := range m {
+		groups[i] = starlark.String(g)
+	}
+	return groups, nil
+}
+
+// scriptFinding implements finding(id, severity, title, detail=""), which
+// records a finding on the port being checked.
+func scriptFinding(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id, severity, title, detail string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "id", &id, "severity", &severity, "title", &title, "detail?", &detail); err != nil {
+		return nil, err
+	}
+	if _, ok := severityRank[severity]; !ok {
+		return nil, fmt.Errorf("%s: unknown severity %q", b.Name(), severity)
+	}
+	sr, ok := thread.Local("run").(*scriptRun)
+	if !ok {
+		return nil, errors.New("finding can only be called from check")
+	}
+	sr.result.addFinding(id, severity, title, detail)
+	return starlark.None, nil
+}
+
+// scriptConn is the connection returned by connect. Its methods are send,
+// read, tls and close.
+type scriptConn struct {
+	conn     net.Conn
+	host     string
+	deadline time.Time // the script's, which no I/O may outlast
+}
+
+func (c *scriptConn) String() string        { return "<conn " + c.conn.RemoteAddr().String() + ">" }
+func (c *scriptConn) Type() string          { return "conn" }
+func (c *scriptConn) Freeze()               {}
+func (c *scriptConn) Truth() starlark.Bool  { return starlark.True }
+func (c *scriptConn) Hash() (uint32, error) { return 0, errors.New("unhashable type: conn") }
+func (c *scriptConn) AttrNames() []string   { return []string{"close", "read", "send", "tls"} }
+
+func (c *scriptConn) Attr(name string) (starlark.Value, error) {
+	var fn func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+	switch name {
+	case "send":
+		fn = c.send
+	case "read":
+		fn = c.read
+	case "tls":
+		fn = func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			return starlark.None, c.upgrade()
+		}
+	case "close":
+		fn = func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+				return nil, err
+			}
+			return starlark.None, c.conn.Close()
+		}
+	default:
+		return nil, nil
+	}
+	return starlark.NewBuiltin(name, fn).BindReceiver(c), nil
+}
+
+// send(data) writes data to the connection.
+func (c *scriptConn) send(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.clamp(timeout)))
+	_, err := c.conn.Write([]byte(data))
+	return starlark.None, err
+}
+
+// read(max=4096, timeout=-timeout) waits up to timeout seconds for the
+// server to send something and returns what it sent, up to max bytes, once
+// it pauses. It returns "" if nothing arrived. The wait never runs past the
+// script's -script-timeout, since cancelling the thread cannot interrupt it.
+func (c *scriptConn) read(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	max := 4096
+	wait := starlark.Value(starlark.Float(timeout.Seconds()))
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "max?", &max, "timeout?", &wait); err != nil {
+		return nil, err
+	}
+	if max < 1 || max > maxBannerBytes {
+		return nil, fmt.Errorf("%s: max must be between 1 and %d", b.Name(), maxBannerBytes)
+	}
+	secs, ok := starlark.AsFloat(wait)
+	if !ok || secs < 0 || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return nil, fmt.Errorf("%s: timeout must be a finite, non-negative number of seconds", b.Name())
+	}
+	d := time.Until(c.deadline)
+	if secs < d.Seconds() {
+		d = time.Duration(secs * float64(time.Second))
+	}
+	return starlark.String(readBanner(c.conn, d, max)), nil
+}
+
+// clamp shortens d so that it ends by the script's deadline.
+func (c *scriptConn) clamp(d time.Duration) time.Duration {
+	if left := time.Until(c.deadline); left < d {
+		return left
+	}
+	return d
+}
+
+// upgrade starts TLS on the connection, e.g. after a STARTTLS command.
+func (c *scriptConn) upgrade() error {
+	tc := tls.Client(c.conn, &tls.Config{ServerName: c.host, InsecureSkipVerify: true})
+	tc.SetDeadline(time.Now().Add(c.clamp(timeout)))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tc
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testScript loads a script whose check function has body as its body.
+func testScript(t *testing.T, body string) *script {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "test.star")
+	src := "def check(port):\n    " + strings.ReplaceAll(body, "\n", "\n    ") + "\n"
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadScript(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScriptReadTimeout(t *testing.T) {
+	defer func(d time.Duration) { scriptTimeout = d }(scriptTimeout)
+	scriptTimeout = 300 * time.Millisecond
+
+	// Reads from a listener that never says anything must still give up
+	// by -script-timeout. Whether the script then fails as timed out
+	// depends on whether the thread is cancelled before the read returns,
+	// so only the time it takes is checked.
+	for _, wait := range []string{"0.05", "3600", "1e300"} {
+		t.Run(wait, func(t *testing.T) {
+			r := fakeServer(t, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+			s := testScript(t, "connect().read(timeout="+wait+")")
+			start := time.Now()
+			s.run(r)
+			if elapsed := time.Since(start); elapsed > 2*scriptTimeout {
+				t.Errorf("read(timeout=%s) took %s with -script-timeout %s", wait, elapsed, scriptTimeout)
+			}
+		})
+	}
+}
+
+func TestScriptReadBadTimeout(t *testing.T) {
+	for _, wait := range []string{"-1", `float("nan")`, `float("inf")`, `"10"`} {
+		t.Run(wait, func(t *testing.T) {
+			r := fakeServer(t, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+			s := testScript(t, "connect().read(timeout="+wait+")")
+			err := s.run(r)
+			if err == nil || !strings.Contains(err.Error(), "timeout must be a finite, non-negative number") {
+				t.Errorf("read(timeout=%s) error = %v, want a timeout error", wait, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+// wellKnownServices maps common TCP ports to the service usually found there.
+var wellKnownServices = map[int]string{
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	25:    "smtp",
+	53:    "domain",
+	80:    "http",
+	110:   "pop3",
+	111:   "rpcbind",
+	135:   "msrpc",
+	139:   "netbios-ssn",
+	143:   "imap",
+	389:   "ldap",
+	443:   "https",
+	445:   "microsoft-ds",
+	465:   "smtps",
+	587:   "submission",
+	631:   "ipp",
+	636:   "ldaps",
+	873:   "rsync",
+	993:   "imaps",
+	995:   "pop3s",
+	1433:  "ms-sql-s",
+	1521:  "oracle",
+	2049:  "nfs",
+	2181:  "zookeeper",
+	2375:  "docker",
+	2376:  "docker-s",
+	3000:  "http-alt",
+	3306:  "mysql",
+	3389:  "ms-wbt-server",
+	4369:  "epmd",
+	5000:  "upnp",
+	5432:  "postgresql",
+	5672:  "amqp",
+	5900:  "vnc",
+	6379:  "redis",
+	6443:  "kubernetes",
+	8000:  "http-alt",
+	8080:  "http-proxy",
+	8443:  "https-alt",
+	9000:  "cslistener",
+	9090:  "zeus-admin",
+	9092:  "kafka",
+	9200:  "elasticsearch",
+	9300:  "elasticsearch",
+	11211: "memcache",
+	27017: "mongodb",
+}
+
+// wellKnownUDPServices maps common UDP ports to the service usually found
+// there.
+var wellKnownUDPServices = map[int]string{
+	53:   "domain",
+	67:   "dhcps",
+	68:   "dhcpc",
+	69:   "tftp",
+	123:  "ntp",
+	137:  "netbios-ns",
+	138:  "netbios-dgm",
+	161:  "snmp",
+	162:  "snmptrap",
+	500:  "isakmp",
+	514:  "syslog",
+	520:  "route",
+	1900: "upnp",
+	4500: "nat-t-ike",
+	5353: "mdns",
+}
+
+// serviceName returns the service usually found on port over protocol, or
+// "unknown".
+func serviceName(protocol string, port int) string {
+	services := wellKnownServices
+	if protocol == protoUDP {
+		services = wellKnownUDPServices
+	}
+	if s, ok := services[port]; ok {
+		return s
+	}
+	return "unknown"
+}
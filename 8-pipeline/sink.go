@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sink is a destination for scan results.
+type Sink interface {
+	Write(Result) error
+	Close() error
+}
+
+// tee hands every result it receives to each of the sinks and then passes it
+// along on the returned channel. Each sink is fed from its own buffered
+// channel so a slow sink only holds up the others once it has fallen more
+// than buffer results behind.
+//
+// A sink that fails stops receiving results; its error, along with any error
+// from closing the sinks, is sent on the returned error channel, which is
+// closed once every sink has been closed.
+func tee(in <-chan Result, buffer int, sinks ...Sink) (<-chan Result, <-chan error) {
+	out := make(chan Result)
+	errc := make(chan error, 2*len(sinks))
+
+	var wg sync.WaitGroup
+	feeds := make([]chan Result, len(sinks))
+	for i, s := range sinks {
+		feeds[i] = make(chan Result, buffer)
+		wg.Add(1)
+		go func(s Sink, feed <-chan Result) {
+			defer wg.Done()
+			var failed bool
+			for r := range feed {
+				if failed {
+					continue // keep draining so tee never blocks on us
+				}
+				if err := s.Write(r); err != nil {
+					errc <- err
+					failed = true
+				}
+			}
+			if err := s.Close(); err != nil {
+				errc <- err
+			}
+		}(s, feeds[i])
+	}
+
+	go func() {
+		defer close(errc)
+		defer wg.Wait()
+		defer close(out)
+		for r := range in {
+			for _, feed := range feeds {
+				feed <- r
+			}
+			out <- r
+		}
+		for _, feed := range feeds {
+			close(feed)
+		}
+	}()
+
+	return out, errc
+}
+
+type csvSink struct {
+	w             io.WriteCloser
+	csvWriter     *csv.Writer
+	headerWritten bool
+}
+
+func newCSVSink(w io.WriteCloser) *csvSink {
+	return &csvSink{w: w, csvWriter: csv.NewWriter(w)}
+}
+
+func (s *csvSink) Write(r Result) error {
+	if !s.headerWritten {
+		if err := s.csvWriter.Write(r.csvHeaders()); err != nil {
+			return err
+		}
+		s.headerWritten = true
+	}
+	return s.csvWriter.Write(r.asSlice())
+}
+
+func (s *csvSink) Close() error {
+	s.csvWriter.Flush()
+	if err := s.csvWriter.Error(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
+
+// jsonSink writes results as a JSON array, one element per line.
+type jsonSink struct {
+	w     io.WriteCloser
+	count int
+}
+
+func newJSONSink(w io.WriteCloser) *jsonSink {
+	return &jsonSink{w: w}
+}
+
+func (s *jsonSink) Write(r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if s.count == 0 {
+		sep = "[\n"
+	}
+	s.count++
+	_, err = fmt.Fprintf(s.w, "%s%s", sep, b)
+	return err
+}
+
+func (s *jsonSink) Close() error {
+	end := "\n]\n"
+	if s.count == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(s.w, end); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
+
+// webhookSink POSTs each result to url as a JSON document.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string, timeout time.Duration) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *webhookSink) Write(r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+var sshProbe bool
+
+func init() {
+	flag.BoolVar(&sshProbe, "ssh", false, "Identify SSH servers and collect their algorithms and host-key fingerprints.")
+}
+
+// sshInfo is what an SSH server revealed before authentication.
+type sshInfo struct {
+	Identification    string       `json:"identification"`
+	ProtocolVersion   string       `json:"protocolVersion"`
+	Software          string       `json:"software"`
+	Comments          string       `json:"comments,omitempty"`
+	KexAlgorithms     []string     `json:"kexAlgorithms"`
+	HostKeyAlgorithms []string     `json:"hostKeyAlgorithms"`
+	Ciphers           []string     `json:"ciphers"`
+	MACs              []string     `json:"macs"`
+	Compression       []string     `json:"compression"`
+	HostKeys          []sshHostKey `json:"hostKeys,omitempty"`
+}
+
+// sshHostKey is one of the server's host keys. The fingerprint is in the
+// format printed by ssh-keygen -l, e.g. "SHA256:uNiVz...".
+type sshHostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// SSH message numbers used before keys are exchanged.
+const (
+	sshMsgIgnore   = 2
+	sshMsgDebug    = 4
+	sshMsgKexInit  = 20
+	sshMsgKexReply = 31
+)
+
+// sshKexAlgorithms are the key exchanges the probe can start, in order of
+// preference. The probe never finishes the exchange, so it only needs to
+// send a public value the server will accept.
+var sshKexAlgorithms = []string{
+	"curve25519-sha256",
+	"curve25519-sha256@libssh.org",
+	"ecdh-sha2-nistp256",
+	"ecdh-sha2-nistp384",
+	"ecdh-sha2-nistp521",
+	"diffie-hellman-group14-sha256",
+	"diffie-hellman-group14-sha1",
+}
+
+// group14Prime is the 2048-bit MODP group from RFC 3526.
+var group14Prime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+	"15728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+
+// probeSSH identifies ports known or announced to run SSH. It reads the
+// server's identification and algorithm lists, then runs one key exchange
+// per host-key type, up to the point where the server sends its host key,
+// to fingerprint every key. It never authenticates.
+func probeSSH(r *Result) {
+	if r.Service != "ssh" && !strings.HasPrefix(r.Banner, "SSH-") && serviceName(r.Protocol, r.Port) != "ssh" {
+		return
+	}
+
+	info, _, err := sshHandshake(r, "")
+	if err != nil {
+		return
+	}
+	for _, alg := range sshHostKeyProbes(info.HostKeyAlgorithms) {
+		if _, key, err := sshHandshake(r, alg); err == nil && key != nil {
+			info.HostKeys = append(info.HostKeys, *key)
+		}
+	}
+
+	r.SSH = info
+	r.Service = "ssh"
+	if r.Product == "" {
+		if i := strings.IndexByte(info.Software, '_'); i > 0 {
+			r.Product, r.Version = info.Software[:i], info.Software[i+1:]
+		} else {
+			r.Product = info.Software
+		}
+		r.ExtraInfo = info.Comments
+	}
+}
+
+// sshHostKeyProbes picks one host-key algorithm for each type of key the
+// server offers. The RSA signature algorithms all use the same key, and
+// certificates are skipped.
+func sshHostKeyProbes(offered []string) []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, alg := range offered {
+		keyType := alg
+		switch alg {
+		case "rsa-sha2-256", "rsa-sha2-512":
+			keyType = "ssh-rsa"
+		}
+		if strings.Contains(alg, "-cert-") || seen[keyType] {
+			continue
+		}
+		seen[keyType] = true
+		algs = append(algs, alg)
+	}
+	return algs
+}
+
+// sshHandshake exchanges identifications and KEXINIT messages with the
+// server. When hostKeyAlg is set, it then starts a key exchange restricted
+// to that host-key algorithm and returns the host key from the reply.
+func sshHandshake(r *Result, hostKeyAlg string) (*sshInfo, *sshHostKey, error) {
+	conn, err := net.DialTimeout("tcp", r.address(), timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	br := bufio.NewReader(conn)
+
+	if _, err := io.WriteString(conn, "SSH-2.0-portscan\r\n"); err != nil {
+		return nil, nil, err
+	}
+	ident, err := readSSHIdentification(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := parseSSHIdentification(ident)
+
+	payload, err := readSSHPacket(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	lists, err := parseKexInit(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.KexAlgorithms = lists[0]
+	info.HostKeyAlgorithms = lists[1]
+	info.Ciphers = lists[2]
+	info.MACs = lists[4]
+	info.Compression = lists[6]
+	if hostKeyAlg == "" {
+		return info, nil, nil
+	}
+
+	kex := firstCommon(sshKexAlgorithms, info.KexAlgorithms)
+	if kex == "" {
+		return info, nil, errors.New("no supported key exchange")
+	}
+	init, err := sshKexInitMessage(kex)
+	if err != nil {
+		return info, nil, err
+	}
+	// The server's own cipher, MAC and compression lists are offered back so
+	// that negotiation cannot fail on anything but the host-key algorithm.
+	kexInit := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	kexInit = append(kexInit, cookie...)
+	for _, list := range [][]string{{kex}, {hostKeyAlg}, lists[2], lists[3], lists[4], lists[5], lists[6], lists[7], nil, nil} {
+		kexInit = appendSSHString(kexInit, []byte(strings.Join(list, ",")))
+	}
+	kexInit = append(kexInit, 0, 0, 0, 0, 0)
+	if err := writeSSHPacket(conn, kexInit); err != nil {
+		return info, nil, err
+	}
+	if err := writeSSHPacket(conn, init); err != nil {
+		return info, nil, err
+	}
+
+	for {
+		payload, err := readSSHPacket(br)
+		if err != nil {
+			return info, nil, err
+		}
+		switch payload[0] {
+		case sshMsgIgnore, sshMsgDebug:
+			continue
+		case sshMsgKexReply:
+			blob, _, ok := readSSHString(payload[1:])
+			if !ok {
+				return info, nil, errors.New("malformed key exchange reply")
+			}
+			keyType, _, ok := readSSHString(blob)
+			if !ok {
+				return info, nil, errors.New("malformed host key")
+			}
+			sum := sha256.Sum256(blob)
+			return info, &sshHostKey{
+				Type:        string(keyType),
+				Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+			}, nil
+		default:
+			return info, nil, fmt.Errorf("unexpected SSH message %d", payload[0])
+		}
+	}
+}
+
+// sshKexInitMessage returns the client's first key exchange message for
+// kex, carrying a fresh public value.
+func sshKexInitMessage(kex string) ([]byte, error) {
+	var pub []byte
+	switch kex {
+	case "curve25519-sha256", "curve25519-sha256@libssh.org":
+		// Any 32 bytes are a valid X25519 public key.
+		pub = make([]byte, 32)
+		if _, err := rand.Read(pub); err != nil {
+			return nil, err
+		}
+	case "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521":
+		curve := map[string]elliptic.Curve{
+			"ecdh-sha2-nistp256": elliptic.P256(),
+			"ecdh-sha2-nistp384": elliptic.P384(),
+			"ecdh-sha2-nistp521": elliptic.P521(),
+		}[kex]
+		_, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub = elliptic.Marshal(curve, x, y)
+	default:
+		x, err := rand.Int(rand.Reader, new(big.Int).Sub(group14Prime, big.NewInt(2)))
+		if err != nil {
+			return nil, err
+		}
+		e := new(big.Int).Exp(big.NewInt(2), x.Add(x, big.NewInt(1)), group14Prime)
+		pub = sshMpint(e)
+	}
+	// SSH_MSG_KEXDH_INIT and SSH_MSG_KEX_ECDH_INIT share a number.
+	return appendSSHString([]byte{30}, pub), nil
+}
+
+// readSSHIdentification reads the server's identification line, skipping
+// any lines the server sends before it.
+func readSSHIdentification(br *bufio.Reader) (string, error) {
+	for i := 0; i < 32; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "SSH-") {
+			return line, nil
+		}
+	}
+	return "", errors.New("no SSH identification")
+}
+
+// parseSSHIdentification splits "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3" into its
+// protocol version, software and comments.
+func parseSSHIdentification(ident string) *sshInfo {
+	info := &sshInfo{Identification: ident}
+	rest := strings.TrimPrefix(ident, "SSH-")
+	if i := strings.IndexByte(rest, ' '); i >= 0 {
+		rest, info.Comments = rest[:i], rest[i+1:]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		info.ProtocolVersion, info.Software = rest[:i], rest[i+1:]
+	}
+	return info
+}
+
+// parseKexInit returns the ten name-lists of a KEXINIT message: key
+// exchange, host key, then ciphers, MACs, compression and languages, each
+// client-to-server followed by server-to-client.
+func parseKexInit(payload []byte) ([][]string, error) {
+	if len(payload) < 17 || payload[0] != sshMsgKexInit {
+		return nil, errors.New("expected SSH KEXINIT")
+	}
+	b := payload[17:]
+	lists := make([][]string, 10)
+	for i := range lists {
+		s, rest, ok := readSSHString(b)
+		if !ok {
+			return nil, errors.New("malformed SSH KEXINIT")
+		}
+		if len(s) > 0 {
+			lists[i] = strings.Split(string(s), ",")
+		}
+		b = rest
+	}
+	return lists, nil
+}
+
+// readSSHPacket reads an unencrypted binary packet and returns its payload.
+func readSSHPacket(br *bufio.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	padding := uint32(hdr[4])
+	if length < padding+2 || length > 256*1024 {
+		return nil, errors.New("malformed SSH packet")
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	return body[:length-1-padding], nil
+}
+
+// writeSSHPacket writes payload as an unencrypted binary packet.
+func writeSSHPacket(w io.Writer, payload []byte) error {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	pkt := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(pkt, uint32(1+len(payload)+padding))
+	pkt[4] = byte(padding)
+	pkt = append(pkt, payload...)
+	pkt = append(pkt, make([]byte, padding)...)
+	_, err := w.Write(pkt)
+	return err
+}
+
+func readSSHString(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
+
+func appendSSHString(b, s []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	return append(append(b, n[:]...), s...)
+}
+
+// sshMpint encodes a positive integer as an SSH mpint body.
+func sshMpint(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// firstCommon returns the first of want that is also in have.
+func firstCommon(want, have []string) string {
+	for _, w := range want {
+		for _, h := range have {
+			if w == h {
+				return w
+			}
+		}
+	}
+	return ""
+}
+
+// hostKeysString formats host keys as space-separated type=fingerprint pairs.
+func (si *sshInfo) hostKeysString() string {
+	var keys []string
+	for _, k := range si.HostKeys {
+		keys = append(keys, k.Type+"="+k.Fingerprint)
+	}
+	return strings.Join(keys, " ")
+}
+
+// parseSSHHostKeys is the inverse of hostKeysString.
+func parseSSHHostKeys(s string) []sshHostKey {
+	var keys []sshHostKey
+	for _, field := range strings.Fields(s) {
+		if i := strings.IndexByte(field, '='); i > 0 {
+			keys = append(keys, sshHostKey{Type: field[:i], Fingerprint: field[i+1:]})
+		}
+	}
+	return keys
+}
+
+// sshColumns returns the CSV columns describing the SSH server, or empty
+// columns when the port did not speak SSH.
+func (r Result) sshColumns() []string {
+	si := r.SSH
+	if si == nil {
+		return make([]string, 6)
+	}
+	return []string{
+		si.Software,
+		strings.Join(si.KexAlgorithms, " "),
+		strings.Join(si.HostKeyAlgorithms, " "),
+		strings.Join(si.Ciphers, " "),
+		strings.Join(si.MACs, " "),
+		si.hostKeysString(),
+	}
+}
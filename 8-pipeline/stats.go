@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the connect-latency histogram. The
+// last bucket catches everything slower than the one before it.
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	0, // +Inf
+}
+
+// scanStats accumulates statistics about every result that passes through
+// its observe stage.
+type scanStats struct {
+	mu          sync.Mutex
+	start       time.Time
+	concurrency int
+	total       int
+	states      map[string]int
+	errClasses  map[string]int
+	latencies   map[string][]time.Duration
+}
+
+func newScanStats(concurrency int) *scanStats {
+	return &scanStats{
+		start:       time.Now(),
+		concurrency: concurrency,
+		states:      make(map[string]int),
+		errClasses:  make(map[string]int),
+		latencies:   make(map[string][]time.Duration),
+	}
+}
+
+// observe is a pipeline stage that records every result passing through it.
+func (s *scanStats) observe(in <-chan Result) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		for r := range in {
+			s.record(r)
+			out <- r
+		}
+	}()
+	return out
+}
+
+func (s *scanStats) record(r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total++
+	s.states[r.State]++
+	if r.ErrClass != "" {
+		s.errClasses[r.ErrClass]++
+	}
+	// Only connects that got an answer say anything about the network;
+	// timeouts would just pile up at the timeout value.
+	if r.State == stateOpen || r.State == stateClosed {
+		s.latencies[r.Host] = append(s.latencies[r.Host], r.ScanDuration)
+	}
+}
+
+// scanSummary is the end-of-scan report.
+type scanSummary struct {
+	Total        int            `json:"total"`
+	States       map[string]int `json:"states"`
+	ErrorClasses map[string]int `json:"errorClasses"`
+	WallTime     time.Duration  `json:"wallTime"`
+	Throughput   float64        `json:"throughputPerSecond"`
+	Concurrency  int            `json:"concurrency"`
+	Hosts        []hostLatency  `json:"hosts"`
+	// Discovery holds the outcome of host discovery, when -discover was given.
+	Discovery []hostStatus `json:"discovery,omitempty"`
+}
+
+// hostLatency summarises the connect latencies observed for one host.
+type hostLatency struct {
+	Host      string            `json:"host"`
+	Samples   int               `json:"samples"`
+	P50       time.Duration     `json:"p50"`
+	P90       time.Duration     `json:"p90"`
+	P99       time.Duration     `json:"p99"`
+	Max       time.Duration     `json:"max"`
+	Histogram []histogramBucket `json:"histogram"`
+}
+
+// histogramBucket counts the samples no slower than UpperBound and faster
+// than the previous bucket's bound. A zero UpperBound means no upper bound.
+type histogramBucket struct {
+	UpperBound time.Duration `json:"upperBound"`
+	Count      int           `json:"count"`
+}
+
+func (s *scanStats) summary() scanSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wall := time.Since(s.start)
+	sum := scanSummary{
+		Total:        s.total,
+		States:       copyCounts(s.states),
+		ErrorClasses: copyCounts(s.errClasses),
+		WallTime:     wall,
+		Throughput:   float64(s.total) / wall.Seconds(),
+		Concurrency:  s.concurrency,
+	}
+
+	for host, lat := range s.latencies {
+		sorted := append([]time.Duration(nil), lat...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		sum.Hosts = append(sum.Hosts, hostLatency{
+			Host:      host,
+			Samples:   len(sorted),
+			P50:       percentile(sorted, 50),
+			P90:       percentile(sorted, 90),
+			P99:       percentile(sorted, 99),
+			Max:       sorted[len(sorted)-1],
+			Histogram: histogram(sorted),
+		})
+	}
+	sort.Slice(sum.Hosts, func(i, j int) bool { return sum.Hosts[i].Host < sum.Hosts[j].Host })
+
+	return sum
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// percentile returns the nearest-rank percentile p of sorted, which must not
+// be empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func histogram(samples []time.Duration) []histogramBucket {
+	buckets := make([]histogramBucket, len(latencyBuckets))
+	for i, ub := range latencyBuckets {
+		buckets[i].UpperBound = ub
+	}
+	for _, d := range samples {
+		for i, ub := range latencyBuckets {
+			if ub == 0 || d <= ub {
+				buckets[i].Count++
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+func (sum scanSummary) print(w io.Writer) {
+	fmt.Fprintln(w, "\nStatistics\n--------------")
+	fmt.Fprintf(w, "Scanned %d ports in %s (%.1f/s) with concurrency %d\n",
+		sum.Total, sum.WallTime.Round(time.Millisecond), sum.Throughput, sum.Concurrency)
+	fmt.Fprintf(w, "States: %s\n", formatCounts(sum.States))
+	if len(sum.ErrorClasses) > 0 {
+		fmt.Fprintf(w, "Errors: %s\n", formatCounts(sum.ErrorClasses))
+	}
+
+	for _, h := range sum.Hosts {
+		fmt.Fprintf(w, "\n%s connect latency (%d samples): p50 %s  p90 %s  p99 %s  max %s\n",
+			h.Host, h.Samples, latency(h.P50), latency(h.P90), latency(h.P99), latency(h.Max))
+		printHistogram(w, h.Histogram)
+	}
+}
+
+func formatCounts(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, m[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// printHistogram draws the non-empty span of buckets as horizontal bars.
+func printHistogram(w io.Writer, buckets []histogramBucket) {
+	const width = 40
+
+	first, last, max := -1, -1, 0
+	for i, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	for _, b := range buckets[first : last+1] {
+		label := "> " + latencyBuckets[len(latencyBuckets)-2].String()
+		if b.UpperBound != 0 {
+			label = "<= " + b.UpperBound.String()
+		}
+		bar := strings.Repeat("#", (b.Count*width+max-1)/max)
+		fmt.Fprintf(w, "  %10s | %-*s %d\n", label, width, bar, b.Count)
+	}
+}
+
+func writeSummary(path string, sum scanSummary) error {
+	b, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ipv4Only bool
+var ipv6Only bool
+
+func init() {
+	flag.BoolVar(&ipv4Only, "4", false, "Only scan IPv4 addresses, resolving hostnames to their IPv4 address.")
+	flag.BoolVar(&ipv6Only, "6", false, "Only scan IPv6 addresses, resolving hostnames to their IPv6 address.")
+}
+
+// maxTargets bounds how many addresses a target spec may expand to, so that
+// a mistyped prefix length, or any IPv6 prefix of realistic size, does not
+// start a scan of a whole network.
+const maxTargets = 1 << 16
+
+// parseTargets parses a comma-separated list of hostnames, IP addresses and
+// CIDR ranges, e.g. "example.com,10.0.0.1,192.168.1.0/24,2001:db8::/120",
+// into the hosts to scan. Ranges are expanded to every address they contain,
+// in order. Link-local IPv6 addresses may name the interface to scan them on
+// as a zone, e.g. "fe80::1%eth0".
+func parseTargets(spec string) ([]string, error) {
+	var hosts []string
+	seen := make(map[string]bool)
+	add := func(h string) error {
+		if seen[h] {
+			return nil
+		}
+		if len(hosts) == maxTargets {
+			return fmt.Errorf("more than %d targets", maxTargets)
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+		return nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		// Brackets are accepted around IPv6 addresses, as in URLs.
+		if strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]") {
+			item = item[1 : len(item)-1]
+		}
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, "%") {
+				if err := checkZone(item); err != nil {
+					return nil, err
+				}
+			}
+			if err := add(item); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target range %s", item)
+		}
+		ones, bits := ipnet.Mask.Size()
+		if bits-ones > 16 {
+			return nil, fmt.Errorf("target range %s has more than %d addresses", item, maxTargets)
+		}
+		ip := ipnet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		for i := 0; i < 1<<uint(bits-ones); i++ {
+			if err := add(ip.String()); err != nil {
+				return nil, err
+			}
+			ip = nextIP(ip)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("unable to determine host(s) to scan")
+	}
+	return hosts, nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// parseIPZone parses an IP address with an optional IPv6 zone, returning nil
+// if s is not an address.
+func parseIPZone(s string) (net.IP, string) {
+	host, zone := s, ""
+	if i := strings.LastIndexByte(s, '%'); i >= 0 {
+		host, zone = s[:i], s[i+1:]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || (zone != "" && ip.To4() != nil) {
+		return nil, ""
+	}
+	return ip, zone
+}
+
+// checkZone checks that a zoned address is an IPv6 address whose zone names
+// an interface of this machine, by name or index.
+func checkZone(s string) error {
+	ip, zone := parseIPZone(s)
+	if ip == nil || zone == "" {
+		return fmt.Errorf("invalid target %s", s)
+	}
+	if _, err := net.InterfaceByName(zone); err == nil {
+		return nil
+	}
+	if index, err := strconv.Atoi(zone); err == nil {
+		if _, err := net.InterfaceByIndex(index); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("target %s: no network interface %s", s, zone)
+}
+
+// inFamily reports whether ip belongs to the address family selected with
+// -4 or -6, if any.
+func inFamily(ip net.IP) bool {
+	switch {
+	case ipv4Only:
+		return ip.To4() != nil
+	case ipv6Only:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+func familyName() string {
+	if ipv6Only {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// target is a host to scan and, when its address has been picked ahead of
+// the scan, the address to connect to.
+type target struct {
+	host string
+	ip   string
+}
+
+// dialHost returns what to connect to for the target.
+func (t target) dialHost() string {
+	if t.ip != "" {
+		return t.ip
+	}
+	return t.host
+}
+
+// expandTargets turns hosts into targets. Hostnames are resolved ahead of
+// the scan when pin is set or an address family was selected, keeping their
+// first address, or with -all-addresses, keeping every address. Addresses
+// outside the selected family are skipped, as are hostnames without any
+// address in it. Other hostnames that do not resolve are kept as they are,
+// so that the scan reports why.
+func expandTargets(hosts []string, res *resolver, pin bool) []target {
+	family := ipv4Only || ipv6Only
+	var targets []target
+	for _, h := range hosts {
+		if ip, _ := parseIPZone(h); ip != nil {
+			if !inFamily(ip) {
+				fmt.Fprintf(os.Stderr, "Skipping %s, which is not an %s address\n", h, familyName())
+				continue
+			}
+			targets = append(targets, target{host: h})
+			continue
+		}
+		if !family && !allAddresses && !pin {
+			targets = append(targets, target{host: h})
+			continue
+		}
+
+		var addrs []string
+		for _, a := range res.resolve(h, false) {
+			if ip, _ := parseIPZone(a); ip != nil && inFamily(ip) {
+				addrs = append(addrs, a)
+			}
+		}
+		if len(addrs) > 1 && !allAddresses {
+			addrs = addrs[:1]
+		}
+		switch {
+		case len(addrs) == 0 && family:
+			fmt.Fprintf(os.Stderr, "Skipping %s, which has no %s address\n", h, familyName())
+		case len(addrs) == 0:
+			targets = append(targets, target{host: h})
+		}
+		for _, a := range addrs {
+			targets = append(targets, target{host: h, ip: a})
+		}
+	}
+	return targets
+}
+
+// urlHost returns addr, a host:port pair, in the form URLs take, where an
+// IPv6 zone's % must be escaped.
+func urlHost(addr string) string {
+	return strings.Replace(addr, "%", "%25", 1)
+}
+
+// hostHeader returns host in the form the HTTP Host header takes, which
+// puts IPv6 addresses in brackets and leaves out their zone.
+func hostHeader(host string) string {
+	if ip, _ := parseIPZone(host); ip != nil && ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return host
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	// Zones must name an interface that exists, so use this machine's.
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no network interfaces")
+	}
+	iface := ifaces[0]
+
+	tests := []struct {
+		spec    string
+		want    []string
+		n       int    // number of hosts, when too many to list
+		wantErr string // prefix of the error
+	}{
+		{spec: "example.com", want: []string{"example.com"}},
+		{spec: "10.0.0.1, example.com ,", want: []string{"10.0.0.1", "example.com"}},
+		{spec: "[::1]", want: []string{"::1"}},
+		{spec: "[::1],::1", want: []string{"::1"}},
+		{spec: "fe80::1%" + iface.Name, want: []string{"fe80::1%" + iface.Name}},
+		{spec: "[fe80::1%" + iface.Name + "]", want: []string{"fe80::1%" + iface.Name}},
+		{spec: "fe80::1%" + strconv.Itoa(iface.Index), want: []string{"fe80::1%" + strconv.Itoa(iface.Index)}},
+		{spec: "10.0.0.1,10.0.0.0/30", want: []string{"10.0.0.1", "10.0.0.0", "10.0.0.2", "10.0.0.3"}},
+		{spec: "192.168.1.7/31", want: []string{"192.168.1.6", "192.168.1.7"}},
+		{spec: "2001:db8::/127", want: []string{"2001:db8::", "2001:db8::1"}},
+		{spec: "2001:db8::/120", n: 256},
+		{spec: "10.1.0.0/16", n: maxTargets},
+		{spec: "fe80::1%nosuchif0", wantErr: "target fe80::1%nosuchif0: no network interface nosuchif0"},
+		{spec: "10.0.0.1%" + iface.Name, wantErr: "invalid target 10.0.0.1%"},
+		{spec: "fe80::1%", wantErr: "invalid target fe80::1%"},
+		{spec: "fe80::/64%" + iface.Name, wantErr: "invalid target range fe80::/64%"},
+		{spec: "fe80::%" + iface.Name + "/120", wantErr: "invalid target range fe80::%"},
+		{spec: "10.0.0.0/33", wantErr: "invalid target range 10.0.0.0/33"},
+		{spec: "10.0.0.0/8", wantErr: "target range 10.0.0.0/8 has more than 65536 addresses"},
+		{spec: "2001:db8::/64", wantErr: "target range 2001:db8::/64 has more than 65536 addresses"},
+		{spec: "10.1.0.0/16,10.2.0.0/31", wantErr: "more than 65536 targets"},
+		{spec: " , []", wantErr: "unable to determine host(s) to scan"},
+	}
+	for _, tt := range tests {
+		hosts, err := parseTargets(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("parseTargets(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTargets(%q): %s", tt.spec, err)
+			continue
+		}
+		if tt.want != nil && !reflect.DeepEqual(hosts, tt.want) {
+			t.Errorf("parseTargets(%q) = %v, want %v", tt.spec, hosts, tt.want)
+		}
+		if tt.n > 0 && len(hosts) != tt.n {
+			t.Errorf("parseTargets(%q) returned %d hosts, want %d", tt.spec, len(hosts), tt.n)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var tlsProbe bool
+var tlsServerName string
+var certExpiryWarn = days(30 * 24 * time.Hour)
+
+func init() {
+	flag.BoolVar(&tlsProbe, "tls", false, "Attempt a TLS handshake on each open port and inspect the certificates.")
+	flag.StringVar(&tlsServerName, "sni", "", "Server name to send in the TLS handshake (defaults to the host).")
+	flag.Var(&certExpiryWarn, "cert-expiry-warn", "Flag certificates expiring within this long (e.g. 30d, 12h).")
+}
+
+// days is a duration flag that also accepts a number of days, as in "30d".
+type days time.Duration
+
+func (d *days) String() string {
+	h := time.Duration(*d).Hours()
+	if h >= 24 && h == float64(int(h/24))*24 {
+		return strconv.Itoa(int(h/24)) + "d"
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *days) Set(s string) error {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return fmt.Errorf("invalid number of days %q", s)
+		}
+		*d = days(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = days(v)
+	return nil
+}
+
+// tlsInfo is what a TLS handshake revealed about a port.
+type tlsInfo struct {
+	Version          string     `json:"version"`
+	CipherSuite      string     `json:"cipherSuite"`
+	ALPN             string     `json:"alpn,omitempty"`
+	ServerName       string     `json:"serverName,omitempty"`
+	Certificates     []certInfo `json:"certificates"`
+	SelfSigned       bool       `json:"selfSigned"`
+	HostnameMismatch bool       `json:"hostnameMismatch"`
+	Expired          bool       `json:"expired"`
+	ExpiresSoon      bool       `json:"expiresSoon"`
+
+	// Enumeration is only filled in by -tls-enum.
+	Enumeration *tlsEnumeration `json:"enumeration,omitempty"`
+}
+
+// certInfo describes one certificate of the chain presented by the server,
+// leaf first.
+type certInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	SHA256    string    `json:"sha256"`
+}
+
+// leaf returns the server's own certificate.
+func (ti *tlsInfo) leaf() *certInfo {
+	if len(ti.Certificates) == 0 {
+		return nil
+	}
+	return &ti.Certificates[0]
+}
+
+// flags lists the problems found with the server's certificate and, when
+// enumerated, its protocol configuration.
+func (ti *tlsInfo) flags() []string {
+	var flags []string
+	if ti.Expired {
+		flags = append(flags, "expired")
+	} else if ti.ExpiresSoon {
+		flags = append(flags, "expires-soon")
+	}
+	if ti.SelfSigned {
+		flags = append(flags, "self-signed")
+	}
+	if ti.HostnameMismatch {
+		flags = append(flags, "hostname-mismatch")
+	}
+	if e := ti.Enumeration; e != nil {
+		if len(e.Deprecated) > 0 {
+			flags = append(flags, "deprecated:"+strings.Join(e.Deprecated, ","))
+		}
+		if len(e.WeakCiphers) > 0 {
+			flags = append(flags, fmt.Sprintf("weak-ciphers:%d", len(e.WeakCiphers)))
+		}
+	}
+	return flags
+}
+
+// tlsColumns returns the CSV columns describing the TLS handshake, or empty
+// columns when the port did not speak TLS.
+func (r Result) tlsColumns() []string {
+	cols := make([]string, 10)
+	ti := r.TLS
+	if ti == nil {
+		return cols
+	}
+	cols[0], cols[1], cols[2] = ti.Version, ti.CipherSuite, ti.ALPN
+	if leaf := ti.leaf(); leaf != nil {
+		cols[3] = leaf.Subject
+		cols[4] = leaf.Issuer
+		cols[5] = strings.Join(leaf.SANs, " ")
+		cols[6] = leaf.NotAfter.Format(time.RFC3339)
+	}
+	cols[7] = strings.Join(ti.flags(), " ")
+	if e := ti.Enumeration; e != nil {
+		var accepted []string
+		for _, v := range e.Versions {
+			if v.Accepted {
+				accepted = append(accepted, v.Version+":"+strings.Join(v.CipherSuites, ","))
+			}
+		}
+		cols[8] = strings.Join(accepted, " ")
+		cols[9] = strings.Join(e.WeakCiphers, " ")
+	}
+	return cols
+}
+
+// inspectTLS attempts a TLS handshake on an open port. Ports that do not
+// speak TLS are left without TLS information.
+func inspectTLS(r *Result) {
+	serverName := tlsServerName
+	if serverName == "" {
+		serverName = r.Host
+	}
+
+	conf := &tls.Config{
+		ServerName: serverName,
+		NextProtos: []string{"h2", "http/1.1"},
+		// Servers stuck on old protocol versions still need to be
+		// found so that they can be reported.
+		MinVersion: tls.VersionTLS10,
+		// Certificates are inspected rather than trusted, so that
+		// broken ones can be reported instead of failing the handshake.
+		InsecureSkipVerify: true,
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", r.address(), conf)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r.TLS = describeTLS(conn.ConnectionState(), serverName, time.Now())
+}
+
+func describeTLS(cs tls.ConnectionState, serverName string, now time.Time) *tlsInfo {
+	ti := &tlsInfo{
+		Version:     tlsVersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		ServerName:  serverName,
+	}
+	for _, cert := range cs.PeerCertificates {
+		sum := sha256.Sum256(cert.Raw)
+		ti.Certificates = append(ti.Certificates, certInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			SANs:      certSANs(cert),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			SHA256:    hex.EncodeToString(sum[:]),
+		})
+	}
+
+	if len(cs.PeerCertificates) > 0 {
+		leaf := cs.PeerCertificates[0]
+		ti.SelfSigned = leaf.Subject.String() == leaf.Issuer.String() && leaf.CheckSignatureFrom(leaf) == nil
+		ti.HostnameMismatch = leaf.VerifyHostname(serverName) != nil
+		ti.Expired = now.After(leaf.NotAfter)
+		ti.ExpiresSoon = now.Add(time.Duration(certExpiryWarn)).After(leaf.NotAfter)
+	}
+	return ti
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionSSL30:
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
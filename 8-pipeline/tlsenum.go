@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"strings"
+)
+
+var tlsEnum bool
+
+func init() {
+	flag.BoolVar(&tlsEnum, "tls-enum", false, "Enumerate the TLS versions and cipher suites accepted by each TLS port (implies -tls).")
+}
+
+// tlsEnumeration is the matrix of TLS versions and cipher suites a port
+// accepts.
+type tlsEnumeration struct {
+	Versions    []tlsVersionSupport `json:"versions"`
+	Deprecated  []string            `json:"deprecated,omitempty"`
+	WeakCiphers []string            `json:"weakCiphers,omitempty"`
+}
+
+type tlsVersionSupport struct {
+	Version      string   `json:"version"`
+	Accepted     bool     `json:"accepted"`
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+}
+
+// enumeratedVersions are the protocol versions offered during enumeration.
+// SSLv3 is not supported by crypto/tls and cannot be tested.
+var enumeratedVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// deprecatedVersion reports whether v has been deprecated by RFC 8996.
+func deprecatedVersion(v uint16) bool {
+	return v < tls.VersionTLS12
+}
+
+// weakCipherSuite reports whether a cipher suite should be flagged: either
+// crypto/tls considers it insecure, or it uses RSA key exchange and so
+// offers no forward secrecy.
+func weakCipherSuite(cs *tls.CipherSuite) bool {
+	return cs.Insecure || strings.HasPrefix(cs.Name, "TLS_RSA_")
+}
+
+// enumerateTLS handshakes repeatedly with a port that is known to speak TLS,
+// each time restricted to a single protocol version and, below TLS 1.3, a
+// single cipher suite, to find out everything the server accepts. TLS 1.3
+// suites cannot be restricted by crypto/tls, so only the one the server
+// picks is recorded for that version.
+func enumerateTLS(r *Result) {
+	if r.TLS == nil {
+		return
+	}
+
+	var suites []*tls.CipherSuite
+	suites = append(suites, tls.CipherSuites()...)
+	suites = append(suites, tls.InsecureCipherSuites()...)
+
+	enum := &tlsEnumeration{}
+	weak := make(map[string]bool)
+	for _, v := range enumeratedVersions {
+		support := tlsVersionSupport{Version: tlsVersionName(v)}
+
+		if v == tls.VersionTLS13 {
+			if cs, ok := tryHandshake(r, v, nil); ok {
+				support.Accepted = true
+				support.CipherSuites = []string{tls.CipherSuiteName(cs.CipherSuite)}
+			}
+			enum.Versions = append(enum.Versions, support)
+			continue
+		}
+
+		var candidates []*tls.CipherSuite
+		var ids []uint16
+		for _, cs := range suites {
+			if supportsVersion(cs, v) {
+				candidates = append(candidates, cs)
+				ids = append(ids, cs.ID)
+			}
+		}
+
+		// One handshake offering every suite tells us whether the
+		// version is accepted at all before trying suites one by one.
+		if _, ok := tryHandshake(r, v, ids); ok {
+			support.Accepted = true
+			for _, cs := range candidates {
+				if _, ok := tryHandshake(r, v, []uint16{cs.ID}); !ok {
+					continue
+				}
+				support.CipherSuites = append(support.CipherSuites, cs.Name)
+				if weakCipherSuite(cs) && !weak[cs.Name] {
+					weak[cs.Name] = true
+					enum.WeakCiphers = append(enum.WeakCiphers, cs.Name)
+				}
+			}
+		}
+		if support.Accepted && deprecatedVersion(v) {
+			enum.Deprecated = append(enum.Deprecated, support.Version)
+		}
+		enum.Versions = append(enum.Versions, support)
+	}
+
+	r.TLS.Enumeration = enum
+}
+
+func supportsVersion(cs *tls.CipherSuite, v uint16) bool {
+	for _, sv := range cs.SupportedVersions {
+		if sv == v {
+			return true
+		}
+	}
+	return false
+}
+
+// tryHandshake attempts a handshake restricted to version v and, if not nil,
+// the given cipher suites.
+func tryHandshake(r *Result, v uint16, suites []uint16) (tls.ConnectionState, bool) {
+	serverName := tlsServerName
+	if serverName == "" {
+		serverName = r.Host
+	}
+	conf := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         v,
+		MaxVersion:         v,
+		CipherSuites:       suites,
+		InsecureSkipVerify: true,
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", r.address(), conf)
+	if err != nil {
+		return tls.ConnectionState{}, false
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"net"
+	"syscall"
+	"time"
+)
+
+var snmpCommunity string
+
+func init() {
+	flag.StringVar(&snmpCommunity, "snmp-community", "public", "SNMP community used when probing UDP port 161.")
+}
+
+// stateOpenFiltered is the state of a UDP port that neither answered nor
+// was reported unreachable: either nothing is listening and the ICMP error
+// was dropped, or a service is listening but ignored the probe.
+const stateOpenFiltered = "open|filtered"
+
+// udpPayloads holds probes for UDP services that only answer well-formed
+// requests. Other ports get an empty datagram.
+var udpPayloads = map[int]func() []byte{
+	53:  dnsQuery,
+	123: ntpRequest,
+	161: snmpGetRequest,
+}
+
+// scanUDP sends a probe datagram on a connected UDP socket. A reply means
+// the port is open; an ICMP port unreachable surfaces as ECONNREFUSED on the
+// connected socket and means it is closed. Silence is open|filtered.
+func scanUDP(r *Result) {
+	start := time.Now()
+	conn, err := net.DialTimeout("udp", r.address(), timeout)
+	if err != nil {
+		r.ScanDuration = time.Since(start)
+		r.ScanErr = err.Error()
+		r.ErrClass = errorClass(err)
+		r.State = stateFromErrClass(r.ErrClass)
+		return
+	}
+	defer conn.Close()
+	r.IP = remoteIP(conn)
+
+	var payload []byte
+	if p, ok := udpPayloads[r.Port]; ok {
+		payload = p()
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(payload)
+	if err == nil {
+		buf := make([]byte, 2048)
+		var n int
+		n, err = conn.Read(buf)
+		if err == nil {
+			r.ScanDuration = time.Since(start)
+			r.Open = true
+			r.State = stateOpen
+			r.Service = serviceName(protoUDP, r.Port)
+			r.BannerRaw = buf[:n]
+			r.Banner = sanitizeBanner(buf[:n])
+			return
+		}
+	}
+	r.ScanDuration = time.Since(start)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		r.State = stateOpenFiltered
+		return
+	}
+	r.ScanErr = err.Error()
+	r.ErrClass = errorClass(err)
+	r.State = stateFromErrClass(r.ErrClass)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		r.State = stateClosed
+	}
+}
+
+// dnsQuery is a recursive query for the root name servers.
+func dnsQuery() []byte {
+	return dnsQuestion(0x1337, ".", dnsTypeNS, dnsClassIN, true)
+}
+
+// ntpRequest is an NTPv4 client request.
+func ntpRequest() []byte {
+	b := make([]byte, 48)
+	b[0] = 0xe3 // leap indicator unknown, version 4, client mode
+	binary.BigEndian.PutUint64(b[40:], uint64(time.Now().Unix()+2208988800)<<32)
+	return b
+}
+
+// snmpGetRequest is an SNMPv2c get-request for sysDescr.0 using the
+// configured community.
+func snmpGetRequest() []byte {
+	sysDescr := []byte{0x2b, 6, 1, 2, 1, 1, 1, 0} // 1.3.6.1.2.1.1.1.0
+	varbind := ber(0x30, ber(0x06, sysDescr), ber(0x05, nil))
+	pdu := ber(0xa0,
+		ber(0x02, []byte{0x13, 0x37}), // request-id
+		ber(0x02, []byte{0}),          // error-status
+		ber(0x02, []byte{0}),          // error-index
+		ber(0x30, varbind),
+	)
+	return ber(0x30,
+		ber(0x02, []byte{1}), // version: v2c
+		ber(0x04, []byte(snmpCommunity)),
+		pdu,
+	)
+}
+
+// ber encodes a BER tag-length-value with the given contents.
+func ber(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+	out := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var where conditions
+
+func init() {
+	flag.Var(&where, "where", `Only keep open ports matching a condition such as http.status=200, http.server~nginx, http.title!= or http.responseTime>500ms; repeatable.`)
+}
+
+// condition compares a result attribute with a value. The operator is one
+// of "=", "!=", "~" (contains, ignoring case), and "<" and ">" for the
+// attributes in orderings.
+type condition struct {
+	attr  string
+	op    string
+	value string
+}
+
+// conditions is a repeatable flag; a result must satisfy all of them.
+type conditions []condition
+
+func (cs *conditions) String() string {
+	var parts []string
+	for _, c := range *cs {
+		parts = append(parts, c.attr+c.op+c.value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Set splits s at the first operator in it, so that values may themselves
+// contain "=", "!=" or "~".
+func (cs *conditions) Set(s string) error {
+	i := strings.IndexAny(s, "!=~<>")
+	var op string
+	switch {
+	case i <= 0:
+	case strings.HasPrefix(s[i:], "!="):
+		op = "!="
+	case s[i] != '!':
+		op = s[i : i+1]
+	}
+	if op == "" {
+		return fmt.Errorf("condition %q must look like attribute=value, attribute!=value, attribute~value, attribute<value or attribute>value", s)
+	}
+	attr, value := s[:i], s[i+len(op):]
+	if _, ok := attrs[attr]; !ok {
+		return fmt.Errorf("unknown attribute %q", attr)
+	}
+	if op == "<" || op == ">" {
+		parse, ok := orderings[attr]
+		if !ok {
+			return fmt.Errorf("attribute %q cannot be compared with %s", attr, op)
+		}
+		if _, err := parse(value); err != nil {
+			return fmt.Errorf("condition %q: %s", s, err)
+		}
+	}
+	*cs = append(*cs, condition{attr: attr, op: op, value: value})
+	return nil
+}
+
+// attrs are the result attributes conditions can refer to.
+var attrs = map[string]func(Result) string{
+	"host":      func(r Result) string { return r.Host },
+	"ip":        func(r Result) string { return r.IP },
+	"hostnames": func(r Result) string { return strings.Join(r.Hostnames, " ") },
+	"protocol":  func(r Result) string { return r.Protocol },
+	"state":     func(r Result) string { return r.State },
+	"service":   func(r Result) string { return r.Service },
+	"product":   func(r Result) string { return r.Product },
+	"version":   func(r Result) string { return r.Version },
+	"banner":    func(r Result) string { return r.Banner },
+	"http.scheme": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return r.HTTP.Scheme
+	},
+	"http.status": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return strconv.Itoa(r.HTTP.StatusCode)
+	},
+	"http.server": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return r.HTTP.Server
+	},
+	"http.title": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return r.HTTP.Title
+	},
+	"http.location": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return r.HTTP.Location
+	},
+	"http.contentLength": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return strconv.FormatInt(r.HTTP.ContentLength, 10)
+	},
+	"http.responseTime": func(r Result) string {
+		if r.HTTP == nil {
+			return ""
+		}
+		return r.HTTP.ResponseTime.String()
+	},
+	"ssh.software": func(r Result) string {
+		if r.SSH == nil {
+			return ""
+		}
+		return r.SSH.Software
+	},
+}
+
+// orderings parse the attributes that "<" and ">" can compare, numbers as
+// they are and durations as nanoseconds.
+var orderings = map[string]func(string) (float64, error){
+	"http.status":        parseNumber,
+	"http.contentLength": parseNumber,
+	"http.responseTime":  parseDuration,
+}
+
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return n, nil
+}
+
+func parseDuration(s string) (float64, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration such as 250ms or 2s", s)
+	}
+	return float64(d), nil
+}
+
+func (c condition) match(r Result) bool {
+	v := attrs[c.attr](r)
+	switch c.op {
+	case "=":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "<", ">":
+		parse := orderings[c.attr]
+		got, err := parse(v)
+		if err != nil {
+			return false
+		}
+		want, _ := parse(c.value)
+		if c.op == "<" {
+			return got < want
+		}
+		return got > want
+	default:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.value))
+	}
+}
+
+// filterWhere is a pipeline stage that drops open ports not matching every
+// condition. Other results pass through so that unfiltered pipelines still
+// see closed ports.
+func filterWhere(in <-chan Result, cs conditions) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+	results:
+		for scan := range in {
+			if scan.Open {
+				for _, c := range cs {
+					if !c.match(scan) {
+						continue results
+					}
+				}
+			}
+			out <- scan
+		}
+	}()
+	return out
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConditionsSet(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    condition
+		wantErr bool
+	}{
+		{s: "http.status=200", want: condition{attr: "http.status", op: "=", value: "200"}},
+		{s: "http.title!=", want: condition{attr: "http.title", op: "!=", value: ""}},
+		{s: "http.server~nginx", want: condition{attr: "http.server", op: "~", value: "nginx"}},
+		{s: "banner~a=b", want: condition{attr: "banner", op: "~", value: "a=b"}},
+		{s: "http.status<400", want: condition{attr: "http.status", op: "<", value: "400"}},
+		{s: "http.contentLength>1024", want: condition{attr: "http.contentLength", op: ">", value: "1024"}},
+		{s: "http.responseTime>500ms", want: condition{attr: "http.responseTime", op: ">", value: "500ms"}},
+		{s: "http.responseTime<1.5s", want: condition{attr: "http.responseTime", op: "<", value: "1.5s"}},
+		{s: "http.status", wantErr: true},
+		{s: "=200", wantErr: true},
+		{s: "http.body=x", wantErr: true},
+		{s: "http.server>nginx", wantErr: true},
+		{s: "http.contentLength>big", wantErr: true},
+		{s: "http.responseTime>500", wantErr: true},
+		{s: "http.status>=300", wantErr: true},
+	}
+	for _, tt := range tests {
+		var cs conditions
+		err := cs.Set(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) = %+v, want an error", tt.s, cs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): %s", tt.s, err)
+			continue
+		}
+		if len(cs) != 1 || cs[0] != tt.want {
+			t.Errorf("Set(%q) = %+v, want %+v", tt.s, cs, tt.want)
+		}
+	}
+}
+
+func TestConditionMatch(t *testing.T) {
+	web := Result{
+		Host:  "www.example.com",
+		Open:  true,
+		State: stateOpen,
+		HTTP: &httpInfo{
+			Scheme:        "https",
+			StatusCode:    301,
+			Server:        "nginx/1.24.0",
+			ContentLength: 2048,
+			ResponseTime:  320 * time.Millisecond,
+		},
+	}
+	ssh := Result{Host: "www.example.com", Open: true, State: stateOpen}
+
+	tests := []struct {
+		cond string
+		r    Result
+		want bool
+	}{
+		{"http.status=301", web, true},
+		{"http.status!=301", web, false},
+		{"http.server~NGINX", web, true},
+		{"http.status<400", web, true},
+		{"http.status>299", web, true},
+		{"http.contentLength>1024", web, true},
+		{"http.contentLength<1024", web, false},
+		{"http.contentLength>2048", web, false},
+		{"http.responseTime>250ms", web, true},
+		{"http.responseTime<250ms", web, false},
+		{"http.responseTime<1s", web, true},
+		// ssh has no HTTP info, so it never satisfies an HTTP comparison.
+		{"http.contentLength<1024", ssh, false},
+		{"http.responseTime<1s", ssh, false},
+	}
+	for _, tt := range tests {
+		var cs conditions
+		if err := cs.Set(tt.cond); err != nil {
+			t.Errorf("Set(%q): %s", tt.cond, err)
+			continue
+		}
+		if got := cs[0].match(tt.r); got != tt.want {
+			t.Errorf("%s matched %+v = %t, want %t", tt.cond, tt.r.HTTP, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,15 @@
 module github.com/jboursiquot/portscan
 
-go 1.16
+go 1.19
 
-require golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+require (
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
+	golang.org/x/net v0.30.0
+	golang.org/x/sync v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+)